@@ -0,0 +1,178 @@
+package security
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
+)
+
+func serveJSON(f flotilla.Ctx, status int, v interface{}) {
+	out, err := json.Marshal(v)
+	if err != nil {
+		f.Call("status", 500)
+		return
+	}
+	f.Call("serveplain", status, string(out))
+}
+
+func serveAPIError(f flotilla.Ctx, status int, code, description string) {
+	serveJSON(f, status, map[string]string{"error": code, "error_description": description})
+}
+
+// apiParams reads the string parameters of a JSON or form encoded request.
+func apiParams(r *http.Request) map[string]string {
+	params := make(map[string]string)
+	if ct, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); ct == "application/json" {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err == nil {
+			for k, v := range body {
+				if s, ok := v.(string); ok {
+					params[k] = s
+				}
+			}
+		}
+		return params
+	}
+	r.ParseForm()
+	for k := range r.PostForm {
+		params[k] = r.PostForm.Get(k)
+	}
+	return params
+}
+
+// familyKey is the TokenStore id marking a refresh token family revoked.
+func familyKey(family string) string {
+	return fmt.Sprintf("refresh-family:%s", family)
+}
+
+// issueTokens returns a short lived access token and a long lived refresh
+// token for usr, both carrying any extra claims. Refresh tokens of one login
+// share a family with their access tokens, revoked as a whole when any of its
+// spent refresh tokens is presented again. Access tokens for an OAuth client, named by a cid claim,
+// come from the oauth_access signatory.
+func (s *Manager) issueTokens(usr user.User, family string, extra token.Claims) map[string]interface{} {
+	signatory := "access"
+	if _, ok := extra["cid"]; ok {
+		signatory = "oauth_access"
+	}
+	access := token.Claims{
+		"ut":  usr.Token("access"),
+		"fam": family,
+		"exp": token.NumericDate(s.Expires("access_duration")),
+	}
+	refresh := token.Claims{
+		"ut":  usr.Token("refresh"),
+		"fam": family,
+		"exp": token.NumericDate(s.Expires("refresh_duration")),
+	}
+	for k, v := range extra {
+		access[k], refresh[k] = v, v
+	}
+	return map[string]interface{}{
+		"access_token":  s.Token(signatory, access),
+		"token_type":    "Bearer",
+		"expires_in":    s.expiresIn("access_duration"),
+		"refresh_token": s.Token("refresh", refresh),
+	}
+}
+
+func (s *Manager) expiresIn(duration string) int64 {
+	return int64(s.Duration(duration) / time.Second)
+}
+
+var (
+	refreshReused  = SecurityError("refresh token reused, all tokens of this login are revoked")
+	refreshInvalid = SecurityError("invalid refresh token")
+	refreshRevoked = SecurityError("refresh token revoked")
+	accessRevoked  = SecurityError("access token revoked")
+)
+
+// familyRevoked reports whether tkn belongs to a token family revoked on
+// refresh token reuse.
+func (s *Manager) familyRevoked(tkn *token.Token) bool {
+	family := claimString(tkn.Claims["fam"])
+	if family == "" {
+		return false
+	}
+	revoked, err := s.tokens.Spent(familyKey(family))
+	return revoked || err != nil
+}
+
+// refreshTokens rotates a refresh token issued to client, an empty client
+// being the token API, returning new tokens of the same family.
+func (s *Manager) refreshTokens(raw, client string) (map[string]interface{}, error) {
+	tkn, err := s.Signatory("refresh").Consume(raw)
+	family := ""
+	if tkn != nil {
+		family = claimString(tkn.Claims["fam"])
+	}
+	if e, ok := err.(*token.ValidationError); ok && e.Errors&token.ValidationErrorSpent != 0 && family != "" {
+		s.tokens.Spend(familyKey(family), token.Expiry(tkn))
+		return nil, refreshReused
+	}
+	if err != nil || family == "" || claimString(tkn.Claims["cid"]) != client {
+		return nil, refreshInvalid
+	}
+	if s.familyRevoked(tkn) {
+		return nil, refreshRevoked
+	}
+	usr, _ := validUserToken(s, tkn)
+	if usr == nil || !usr.Active() {
+		return nil, refreshInvalid
+	}
+	var extra token.Claims
+	if client != "" {
+		extra = token.Claims{"cid": client, "scope": tkn.Claims["scope"]}
+	}
+	return s.issueTokens(usr, family, extra), nil
+}
+
+// postIssueToken issues tokens for an email and password, and a TOTP or
+// recovery code for users with two-factor enabled.
+func postIssueToken(f flotilla.Ctx) {
+	s, r := manager(f), request(f)
+	p := apiParams(r)
+	throttle := s.BoolSetting("throttle")
+	keys := s.attemptKeys(r, p["email"])
+	if refusal := s.refusal(keys); throttle && refusal != nil {
+		_, out := s.fmtMessage(refusal...)
+		serveAPIError(f, 429, "invalid_grant", out)
+		return
+	}
+	usr, err := s.CheckCredentials(p["email"], p["password"])
+	if err != nil {
+		if throttle {
+			s.failAttempt(f, keys)
+		}
+		serveAPIError(f, 401, "invalid_grant", err.Error())
+		return
+	}
+	if tf, ok := s.twoFactor(usr); ok {
+		if _, verified := s.checkSecondFactor(usr, tf, p["code"]); !verified {
+			if throttle {
+				s.failAttempt(f, keys)
+			}
+			_, out := s.fmtMessage("invalid_two_factor_code")
+			serveAPIError(f, 401, "invalid_grant", out)
+			return
+		}
+	}
+	s.resetAccount(p["email"])
+	serveJSON(f, 200, s.issueTokens(usr, token.NewTokenID(), nil))
+}
+
+func postRefreshToken(f flotilla.Ctx) {
+	s, p := manager(f), apiParams(request(f))
+	resp, err := s.refreshTokens(p["refresh_token"], "")
+	if err != nil {
+		serveAPIError(f, 401, "invalid_grant", err.Error())
+		return
+	}
+	serveJSON(f, 200, resp)
+}
@@ -0,0 +1,67 @@
+package security
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/principal"
+	"github.com/thrisp/security/user"
+)
+
+// bearerToken returns the token of an Authorization: Bearer header.
+func bearerToken(r *http.Request) string {
+	if ah := r.Header.Get("Authorization"); len(ah) > 7 && strings.EqualFold(ah[:7], "Bearer ") {
+		return strings.TrimSpace(ah[7:])
+	}
+	return ""
+}
+
+// BearerUser returns the active user named by the ut claim of a valid bearer
+// token minted by the BEARER_SIGNATORY, or the anonymous user. The result is
+// kept for the rest of the request.
+func (s *Manager) BearerUser(f flotilla.Ctx) user.User {
+	if u, _ := f.Call("get", "bearer_user"); u != nil {
+		if usr, ok := u.(user.User); ok {
+			return usr
+		}
+	}
+	usr := s.bearerUser(f)
+	f.Call("set", "bearer_user", usr)
+	return usr
+}
+
+func (s *Manager) bearerUser(f flotilla.Ctx) user.User {
+	sig := s.Setting("bearer_signatory")
+	t := bearerToken(request(f))
+	if sig == "" || t == "" {
+		return user.AnonymousUser
+	}
+	tkn, err := s.Signatory(sig).Valid(t)
+	if err != nil || s.familyRevoked(tkn) {
+		return user.AnonymousUser
+	}
+	if usr, _ := validUserToken(s, tkn); usr != nil && usr.Active() {
+		return usr
+	}
+	return user.AnonymousUser
+}
+
+// bearerIdentity is a principal.IdentityLoader providing the identity of a
+// bearer token user.
+func (s *Manager) bearerIdentity(f flotilla.Ctx) principal.Identity {
+	if usr := s.BearerUser(f); !usr.Anonymous() {
+		return usr
+	}
+	return principal.Anonymous
+}
+
+// authenticateBearer makes a bearer token user the current user for this
+// request. The user is set as the session "user", which every request
+// reloads from the session user token, and no user token or remember cookie
+// is set, so the login does not outlast the request.
+func (s *Manager) authenticateBearer(f flotilla.Ctx) {
+	if usr := s.BearerUser(f); !usr.Anonymous() {
+		s.login.LoginRequest(usr)
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"net/http"
 
 	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/login"
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
 )
 
 func request(f flotilla.Ctx) *http.Request {
@@ -69,6 +72,10 @@ func LoginRequired(h flotilla.Manage) flotilla.Manage {
 }
 
 func Unauthenticated(f flotilla.Ctx, s *Manager) {
+	if bearerToken(request(f)) != "" {
+		f.Call("status", 401)
+		return
+	}
 	s.Flash(f, "unauthenticated")
 	if h := s.login.Reloaders["unauthenticated"]; h != nil {
 		h(f)
@@ -87,14 +94,14 @@ func getLogin(f flotilla.Ctx) {
 }
 
 func postLogin(f flotilla.Ctx) {
-	posted(
+	throttledPosted(
 		f,
 		"login",
+		"user-name",
 		func(f flotilla.Ctx, s *Manager, form Form) {
 			user, _ := formUser(form)
 			remember, _ := formRememberMe(form)
-			s.LoginUser(user, remember, f)
-			s.redirectAfter(f, form, "login_successful")
+			s.loginOrPend(f, user, remember, s.nxtAbsolute(request(f), form))
 		},
 	)
 }
@@ -104,11 +111,17 @@ func getSendLogin(f flotilla.Ctx) {
 }
 
 func postSendLogin(f flotilla.Ctx) {
-	posted(
+	throttledSend(
 		f,
 		"passwordless_login",
+		"user-name",
 		func(f flotilla.Ctx, s *Manager, form Form) {
 			s.sendNotice(f, form, "getPasswordlessToken", "passwordless")
+			if s.BoolSetting("passwordless_code") {
+				s.Flash(f, "login_code_sent")
+				f.Call("redirect", 303, s.BlueprintUrl("passwordless_code_url"))
+				return
+			}
 			s.redirectAfter(f, form, "login_email_sent")
 		},
 	)
@@ -116,14 +129,17 @@ func postSendLogin(f flotilla.Ctx) {
 
 func tokenLogin(f flotilla.Ctx) {
 	s, t := manager(f), tokenFromUrl(f, "token")
-	tkn, err := s.Signatory("passwordless").Valid(t)
+	tkn, err := s.Signatory("passwordless").Consume(t)
 	if err != nil {
 		s.forwardTo(f, "passwordless_login.html", "invalid_login_token")
-	} else {
-		usr, remember := validUserToken(s, tkn)
-		s.LoginUser(usr, remember, f)
-		s.redirectAfter(f, nil, "login_successful")
+		return
+	}
+	usr, remember := validUserToken(s, tkn)
+	if usr == nil {
+		s.forwardTo(f, "passwordless_login.html", "invalid_login_token")
+		return
 	}
+	s.loginOrPend(f, usr, remember, s.nxtAbsolute(request(f), nil))
 }
 
 func getSendReset(f flotilla.Ctx) {
@@ -131,9 +147,10 @@ func getSendReset(f flotilla.Ctx) {
 }
 
 func postSendReset(f flotilla.Ctx) {
-	posted(
+	throttledSend(
 		f,
 		"send_reset",
+		"user-name",
 		func(f flotilla.Ctx, s *Manager, form Form) {
 			s.sendNotice(f, form, "getResetToken", "send_reset")
 			_, email := formUser(form)
@@ -145,13 +162,20 @@ func postSendReset(f flotilla.Ctx) {
 func getResetPassword(f flotilla.Ctx) {
 	s, t := manager(f), tokenFromUrl(f, "token")
 	tkn, err := s.Signatory("send_reset").Valid(t)
-	if err != nil {
+	var usr user.User
+	if err == nil {
+		usr, _ = validUserToken(s, tkn)
+	}
+	if usr == nil || changedSince(usr, claimTime(tkn.Claims["iat"])) {
 		s.forwardTo(f, "send_reset.html", "invalid_reset_token")
 	} else {
-		usr, _ := validUserToken(s, tkn)
-		fu := fmt.Sprintf("forUser:%s", usr.Email())
-		vr := fmt.Sprintf("validReset:%s", s.Signatory("reset_password").SignedString())
-		form := s.Forms.byKey("reset_password").Fresh(fu, vr)
+		form := s.Forms.byKey("reset_password").Fresh(token.Claims{
+			"forUser":     usr.Email(),
+			"validReset":  s.Signatory("reset_password").SignedWith(nil),
+			"resetId":     tkn.Claims["jti"],
+			"resetUntil":  token.NumericDate(token.Expiry(tkn)),
+			"resetIssued": tkn.Claims["iat"],
+		})
 		f.Call("set", form.Tag(), form)
 		f.Call("rendertemplate", "reset_password.html", nil)
 	}
@@ -165,14 +189,38 @@ func postResetPassword(f flotilla.Ctx) {
 			t, err := s.Signatory("signed").Valid(formSigned(form))
 			if err != nil {
 				s.formFail(f, form, "send_reset.html")
+				return
 			}
 			usr := s.Get(claimString(t.Claims["forUser"]))
+			if usr.Anonymous() || changedSince(usr, claimTime(t.Claims["resetIssued"])) {
+				s.forwardTo(f, "send_reset.html", "invalid_reset_token")
+				return
+			}
 			newpassword := formPassword(form, "confirmable-one")
-			usr.Update("password", newpassword)
+			if s.reusedPassword(usr, newpassword) {
+				f.Call("set", form.Tag(), form)
+				s.forwardTo(f, "reset_password.html", "password_is_the_same")
+				return
+			}
+			t, err = s.Signatory("signed").Consume(formSigned(form))
+			if err == nil {
+				err = s.changePassword(usr, newpassword)
+			}
+			if err == nil {
+				err = s.Signatory("send_reset").Revoke(claimString(t.Claims["resetId"]), claimTime(t.Claims["resetUntil"]))
+			}
+			if err != nil {
+				s.formFail(f, form, "send_reset.html")
+				return
+			}
 			if s.BoolSetting("notify_password_reset") {
 				s.sendNotice(f, form, "getResetToken", "reset_password")
 			}
 			remember, _ := formRememberMe(form)
+			if _, ok := s.twoFactor(usr); ok {
+				s.pendTwoFactor(f, usr, remember, s.nxtAbsolute(request(f), form))
+				return
+			}
 			s.LoginUser(usr, remember, f)
 			s.redirectAfter(f, form, "reset_successful")
 		},
@@ -188,9 +236,17 @@ func postChangePassword(f flotilla.Ctx) {
 		f,
 		"change_password",
 		func(f flotilla.Ctx, s *Manager, form Form) {
-			usr, _ := formUser(form)
+			usr := s.CurrentUser()
 			newpassword := formPassword(form, "confirmable-one")
-			usr.Update("password", newpassword)
+			if s.reusedPassword(usr, newpassword) {
+				f.Call("set", form.Tag(), form)
+				s.forwardTo(f, "change_password.html", "password_is_the_same")
+				return
+			}
+			if err := s.changePassword(usr, newpassword); err != nil {
+				s.formFail(f, form, "change_password.html")
+				return
+			}
 			if s.BoolSetting("notify_password_change") {
 				s.sendNotice(f, form, "getResetToken", "reset_password")
 			}
@@ -209,9 +265,9 @@ func postRegister(f flotilla.Ctx) {
 		"register",
 		func(f flotilla.Ctx, s *Manager, form Form) {
 			_, usr := formUser(form)
-			password := formPassword(form, "confirmable-one")
-			if _, err := s.New(usr, password); err != nil {
+			if _, err := s.newUser(usr, formPassword(form, "confirmable-one")); err != nil {
 				s.formFail(f, form, "register.html")
+				return
 			}
 			if s.BoolSetting("confirmable") {
 				sendConfirm(f, s, form)
@@ -241,6 +297,18 @@ func postSendConfirm(f flotilla.Ctx) {
 }
 
 func getConfirmUser(f flotilla.Ctx) {
+	s, t := manager(f), tokenFromUrl(f, "token")
+	tkn, err := s.Signatory("send_confirm").Valid(t)
+	if err != nil {
+		s.forwardTo(f, "send_confirm.html", "invalid_confirmation_token")
+		return
+	}
+	form := s.Forms.byKey("confirm_user").Fresh(token.Claims{
+		"ut":           tkn.Claims["ut"],
+		"confirmId":    tkn.Claims["jti"],
+		"confirmUntil": token.NumericDate(token.Expiry(tkn)),
+	})
+	f.Call("set", form.Tag(), form)
 	f.Call("rendertemplate", "confirm_user.html", nil)
 }
 
@@ -250,6 +318,23 @@ func postConfirmUser(f flotilla.Ctx) {
 		"confirm_user",
 		func(f flotilla.Ctx, s *Manager, form Form) {
 			usr, _ := formUser(form)
+			t, err := s.Signatory("signed").Valid(formSigned(form))
+			if err != nil {
+				s.formFail(f, form, "send_confirm.html")
+				return
+			}
+			if confirming, _ := validUserToken(s, t); confirming == nil || usr == nil || confirming.Email() != usr.Email() {
+				s.forwardTo(f, "send_confirm.html", "invalid_confirmation_token")
+				return
+			}
+			t, err = s.Signatory("signed").Consume(formSigned(form))
+			if err == nil {
+				err = s.Signatory("send_confirm").Revoke(claimString(t.Claims["confirmId"]), claimTime(t.Claims["confirmUntil"]))
+			}
+			if err != nil {
+				s.forwardTo(f, "send_confirm.html", "invalid_confirmation_token")
+				return
+			}
 			var mess string
 			if usr.Confirmed() {
 				mess = "already_confirmed"
@@ -265,6 +350,10 @@ func postConfirmUser(f flotilla.Ctx) {
 	)
 }
 
+func getJWKS(f flotilla.Ctx) {
+	serveJSON(f, 200, manager(f).JWKSet())
+}
+
 func securityRouteConfig(name, method, base string, m []flotilla.Manage) flotilla.RouteConf {
 	return func(rt *flotilla.Route) error {
 		rt.Rename(name)
@@ -290,11 +379,44 @@ func makeBlueprint(s *Manager) *flotilla.Blueprint {
 		SecurityRoute(bp, "postLogin", "POST", lurl, AnonymousRequired(postLogin))
 	}
 
+	if s.BoolSetting("throttle") && !s.Passwordless() {
+		SecurityRoute(bp, "getUnlock", "GET", s.Url("unlock_url"), AnonymousRequired(getUnlock))
+	}
+
 	if s.Passwordless() {
 		plurl := s.Url("passwordless_url")
 		SecurityRoute(bp, "getSendLogin", "GET", plurl, AnonymousRequired(getSendLogin))
 		SecurityRoute(bp, "postSendLogin", "POST", plurl, AnonymousRequired(postSendLogin))
 		SecurityRoute(bp, "getPasswordlessToken", "GET", s.Url("passwordless_token_url"), AnonymousRequired(tokenLogin))
+		if s.BoolSetting("passwordless_code") {
+			curl := s.Url("passwordless_code_url")
+			SecurityRoute(bp, "getLoginCode", "GET", curl, AnonymousRequired(getLoginCode))
+			SecurityRoute(bp, "postLoginCode", "POST", curl, AnonymousRequired(postLoginCode))
+		}
+	}
+
+	if s.BoolSetting("two_factor") {
+		turl, surl := s.Url("two_factor_url"), s.Url("two_factor_setup_url")
+		SecurityRoute(bp, "getTwoFactor", "GET", turl, AnonymousRequired(getTwoFactor))
+		SecurityRoute(bp, "postTwoFactor", "POST", turl, AnonymousRequired(postTwoFactor))
+		SecurityRoute(bp, "getTwoFactorSetup", "GET", surl, LoginRequired(replaceTwoFactor(getTwoFactorSetup)))
+		SecurityRoute(bp, "postTwoFactorSetup", "POST", surl, LoginRequired(replaceTwoFactor(postTwoFactorSetup)))
+		rurl := s.Url("recovery_codes_url")
+		SecurityRoute(bp, "getRecoveryCodes", "GET", rurl, LoginRequired(login.RefreshRequired(getRecoveryCodes)))
+		SecurityRoute(bp, "postRecoveryCodes", "POST", rurl, LoginRequired(login.RefreshRequired(postRecoveryCodes)))
+	}
+
+	if s.BoolSetting("passkeys") {
+		rurl, lurl := s.Url("passkey_register_url"), s.Url("passkey_login_url")
+		SecurityRoute(bp, "getPasskeyRegister", "GET", rurl, LoginRequired(getPasskeyRegister))
+		SecurityRoute(bp, "postPasskeyRegister", "POST", rurl, LoginRequired(postPasskeyRegister))
+		SecurityRoute(bp, "getPasskeyLogin", "GET", lurl, AnonymousRequired(getPasskeyLogin))
+		SecurityRoute(bp, "postPasskeyLogin", "POST", lurl, AnonymousRequired(postPasskeyLogin))
+	}
+
+	if s.BoolSetting("oidc") {
+		SecurityRoute(bp, "getOIDCLogin", "GET", s.Url("oidc_login_url"), AnonymousRequired(getOIDCLogin))
+		SecurityRoute(bp, "getOIDCCallback", "GET", s.Url("oidc_callback_url"), AnonymousRequired(getOIDCCallback))
 	}
 
 	if s.BoolSetting("recoverable") {
@@ -307,8 +429,12 @@ func makeBlueprint(s *Manager) *flotilla.Blueprint {
 
 	if s.BoolSetting("changeable") {
 		curl := s.Url("change_url")
-		SecurityRoute(bp, "getChangePassword", "GET", curl, LoginRequired(getChangePassword))
-		SecurityRoute(bp, "postChangePassword", "POST", curl, LoginRequired(postChangePassword))
+		getChange, postChange := getChangePassword, postChangePassword
+		if s.BoolSetting("change_refresh_required") {
+			getChange, postChange = login.RefreshRequired(getChange), login.RefreshRequired(postChange)
+		}
+		SecurityRoute(bp, "getChangePassword", "GET", curl, LoginRequired(getChange))
+		SecurityRoute(bp, "postChangePassword", "POST", curl, LoginRequired(postChange))
 	}
 
 	if s.BoolSetting("registerable") {
@@ -325,5 +451,24 @@ func makeBlueprint(s *Manager) *flotilla.Blueprint {
 		SecurityRoute(bp, "postConfirmUser", "POST", s.Url("confirm_user_url"), postConfirmUser)
 	}
 
+	if s.BoolSetting("token_api") {
+		if s.Setting("bearer_signatory") == "" {
+			panic(ConfigurationError("TOKEN_API requires a BEARER_SIGNATORY to accept the tokens it issues"))
+		}
+		SecurityRoute(bp, "postIssueToken", "POST", s.Url("issue_token_url"), postIssueToken)
+		SecurityRoute(bp, "postRefreshToken", "POST", s.Url("refresh_token_url"), postRefreshToken)
+	}
+
+	if s.BoolSetting("oauth") {
+		aurl := s.Url("authorize_url")
+		SecurityRoute(bp, "getAuthorize", "GET", aurl, rememberAuthorize(LoginRequired(getAuthorize)))
+		SecurityRoute(bp, "postAuthorize", "POST", aurl, LoginRequired(postAuthorize))
+		SecurityRoute(bp, "postOAuthToken", "POST", s.Url("oauth_token_url"), postOAuthToken)
+	}
+
+	if s.BoolSetting("jwks") {
+		SecurityRoute(bp, "getJWKS", "GET", s.Url("jwks_url"), getJWKS)
+	}
+
 	return bp
 }
@@ -2,7 +2,9 @@ package security
 
 import (
 	"strings"
+	"time"
 
+	"github.com/thrisp/security/token"
 	"github.com/thrisp/security/user"
 )
 
@@ -36,9 +38,92 @@ func WithSettings(items ...string) Configuration {
 	}
 }
 
+// WithTokenStore sets where signatories record spent token ids. The default
+// is an in-memory store, which forgets them on restart.
+func WithTokenStore(ts token.TokenStore) Configuration {
+	return func(s *Manager) error {
+		s.tokens = ts
+		return nil
+	}
+}
+
+// WithOAuthClients sets the clients the OAuth authorization server, enabled
+// with the OAUTH setting, accepts.
+func WithOAuthClients(c OAuthClients) Configuration {
+	return func(s *Manager) error {
+		s.clients = c
+		return nil
+	}
+}
+
+// WithOIDCProviders adds OpenID Connect issuers users may log in with, when
+// the OIDC setting is enabled.
+func WithOIDCProviders(providers ...*OIDCProvider) Configuration {
+	return func(s *Manager) error {
+		if s.providers == nil {
+			s.providers = make(map[string]*OIDCProvider)
+		}
+		for _, p := range providers {
+			s.providers[p.Name] = p
+		}
+		return nil
+	}
+}
+
+// WithAttemptStore sets where failed login attempts are counted for
+// throttling. The default is an in-memory store, which is not shared between
+// processes.
+func WithAttemptStore(a AttemptStore) Configuration {
+	return func(s *Manager) error {
+		s.attempts = a
+		return nil
+	}
+}
+
+// WithSendCounter sets where emails sent are counted for the EMAIL_ limit
+// settings. The default is an in-memory counter, which is not shared between
+// processes.
+func WithSendCounter(c SendCounter) Configuration {
+	return func(s *Manager) error {
+		s.sends = c
+		return nil
+	}
+}
+
+// WithPasswordHashers sets the hashers for users keeping a password hash. The
+// first hashes new passwords; the others only verify older hashes, which are
+// replaced on the next successful login.
+func WithPasswordHashers(h ...PasswordHasher) Configuration {
+	return func(s *Manager) error {
+		s.hashers = h
+		return nil
+	}
+}
+
+// WithPasswordPolicy sets the rules for new passwords in place of those made
+// from the PASSWORD_ settings.
+func WithPasswordPolicy(p *PasswordPolicy) Configuration {
+	return func(s *Manager) error {
+		s.policy = p
+		return nil
+	}
+}
+
 func WithEmailer(e Emailer) Configuration {
 	return func(s *Manager) error {
 		s.Emailer = e
 		return nil
 	}
 }
+
+// WithRetiredKey keeps a previous SECRET_KEY and SIGNATORY_ENCRYPTION_KEY,
+// identified by kid, for verifying and opening outstanding tokens until the
+// given time. The secret key is consulted for tokens carrying its kid, or
+// none when they predate SIGNATORY_KEY_ID, which must be set to name the
+// current keys.
+func WithRetiredKey(kid, secretKey, encryptionKey string, until time.Time) Configuration {
+	return func(s *Manager) error {
+		s.retired = append(s.retired, retiredKey{kid, secretKey, encryptionKey, until})
+		return nil
+	}
+}
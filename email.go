@@ -7,6 +7,7 @@ import (
 	"net/smtp"
 
 	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
 )
 
 type Emailer interface {
@@ -81,17 +82,39 @@ func (s *Manager) SendMail(template string, to string, link string) error {
 	return s.Emailer.Send(to, b.Bytes())
 }
 
+// noticeSignatories are the signatories minting notice links, by the route
+// the link is for, whichever form sent the notice.
+var noticeSignatories = map[string]string{
+	"getPasswordlessToken": "passwordless",
+	"getResetToken":        "send_reset",
+	"getConfirmUser":       "send_confirm",
+}
+
 func (s *Manager) sendNotice(f flotilla.Ctx, form Form, forRoute string, template string) error {
 	user, email := formUser(form)
-	_, remember := formRememberMe(form)
+	if email == "" {
+		user = s.CurrentUser()
+		email = user.Email()
+	}
+	if s.sendLimited(f, email) {
+		return nil
+	}
+	remember, _ := formRememberMe(form)
 	tag := form.Tag()
-	var ut string
+	if sig, ok := noticeSignatories[forRoute]; ok {
+		tag = sig
+	}
+	claims := token.Claims{
+		"remember": remember,
+		"exp":      token.NumericDate(s.Expires(fmt.Sprintf("%s_DURATION", tag))),
+	}
 	if user != nil {
-		ut = fmt.Sprintf("ut:%s", user.Token(tag))
+		claims["ut"] = user.Token(tag)
 	}
-	rm := fmt.Sprintf("remember:%s", remember)
-	expiration := s.Expiration(fmt.Sprintf("%s_DURATION", tag))
-	sendToken := s.Token(tag, ut, rm, expiration)
+	if tag == "passwordless" && s.BoolSetting("passwordless_code") {
+		return s.sendLoginCode(f, email, claims)
+	}
+	sendToken := s.Token(tag, claims)
 	link := s.External(f, forRoute, sendToken)
 	return s.SendMail(template, email, link)
 }
@@ -102,6 +125,11 @@ var defaultemailtemplates = map[string]string{
 Please log into your account through the link below:
 
 {{ .Link }}`,
+	"passwordless_code": `Welcome {{ .Email }}!
+
+Please log into your account with the code below, in the browser where you asked for it:
+
+{{ .Code }}`,
 	"send_reset": `Greetings {{ .Email }},
 	
 Click the link below to reset your password:
@@ -115,6 +143,11 @@ If you did not change your password, click the link below to reset it.
 
 {{ .Link }}
 `,
+	"unlock": `Greetings {{ .Email }},
+
+Your account has been locked after too many failed attempts to log in. Click the link below to unlock it:
+
+{{ .Link }}`,
 	"send_confirm": `Greetings {{ .Email }},
 
 Please confirm your email through the link below:
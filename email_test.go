@@ -17,6 +17,7 @@ type testEmailer struct {
 	templates EmailTemplates
 	last      string
 	lastToken string
+	sent      int
 }
 
 func (te *testEmailer) Render(name string, data map[string]interface{}) (*bytes.Buffer, error) {
@@ -33,6 +34,7 @@ func extractToken(data []byte) string {
 }
 
 func (te *testEmailer) Send(name string, data []byte) error {
+	te.sent++
 	te.last = string(data)
 	te.lastToken = extractToken(data)
 	return nil
@@ -94,21 +94,29 @@ func ValidEmail(u *userName) error {
 
 func (s *Manager) ValidUserName(u *userName) error {
 	if u.Validateable() {
-		if u.UserName == "" {
-			return MsgError(s, "email_not_provided")
-		}
-		usr := s.Get(u.UserName)
-		if usr.Anonymous() {
-			return MsgError(s, "user_does_not_exist")
-		}
-		if !usr.Active() {
-			return MsgError(s, "disabled_account")
+		usr, err := s.activeUser(u.UserName)
+		if err != nil {
+			return err
 		}
 		u.user = usr
 	}
 	return nil
 }
 
+func (s *Manager) activeUser(email string) (user.User, error) {
+	if email == "" {
+		return nil, MsgError(s, "email_not_provided")
+	}
+	usr := s.Get(email)
+	if usr.Anonymous() {
+		return nil, MsgError(s, "user_does_not_exist")
+	}
+	if !usr.Active() {
+		return nil, MsgError(s, "disabled_account")
+	}
+	return usr, nil
+}
+
 func PassWord(name string, options ...string) fork.Field {
 	return fork.PassWordField(name, nil, nil, options...)
 }
@@ -168,7 +176,7 @@ func (n *next) Set(r *http.Request) {
 type signed struct {
 	*securityName
 	signatory token.Signatory
-	claims    []string
+	claims    token.Claims
 	returned  string
 	fork.Processor
 }
@@ -192,16 +200,23 @@ func Signed(name string, s token.Signatory, options ...string) fork.Field {
 func (s *signed) New(i ...interface{}) fork.Field {
 	var newfield signed = *s
 	newfield.returned = ""
-	newfield.claims = toStr(i)
+	newfield.claims = toClaims(i)
 	newfield.SetValidateable(false)
 	return &newfield
 }
 
-func toStr(i []interface{}) []string {
-	var ret []string
+func toClaims(i []interface{}) token.Claims {
+	ret := make(token.Claims)
 	for _, v := range i {
-		if st, ok := v.(string); ok {
-			ret = append(ret, st)
+		switch c := v.(type) {
+		case token.Claims:
+			for k, cv := range c {
+				ret[k] = cv
+			}
+		case string:
+			for k, cv := range token.StringClaims(c) {
+				ret[k] = cv
+			}
 		}
 	}
 	return ret
@@ -217,7 +232,7 @@ func (s *signed) Set(r *http.Request) {
 }
 
 func (s *signed) Token() string {
-	return s.signatory.SignedString(s.claims...)
+	return s.signatory.SignedWith(s.claims)
 }
 
 var InvalidSignedField = SecurityError("Invalid signed field: %s").Out
@@ -230,3 +245,134 @@ func ValidateSigned(s *signed) error {
 	}
 	return nil
 }
+
+func TwoFactorCode(name string, options ...string) fork.Field {
+	options = append([]string{`placeholder="authentication code"`, `autocomplete="one-time-code"`}, options...)
+	return fork.TextField(name, nil, nil, options...)
+}
+
+type otpauth struct {
+	*securityName
+	URI string
+	fork.Processor
+}
+
+func otpauthWidget(options ...string) fork.Widget {
+	return fork.NewWidget(fmt.Sprintf(`<div class="security-otpauth" data-otpauth="{{ .URI }}" %s><code>{{ .URI }}</code></div>`, strings.Join(options, " ")))
+}
+
+// OTPAuth displays the otpauth:// URI a two-factor secret is enrolled from,
+// given to New, for rendering as a QR code.
+func OTPAuth(name string, options ...string) fork.Field {
+	return &otpauth{
+		securityName: &securityName{name},
+		Processor: fork.NewProcessor(
+			otpauthWidget(options...),
+			fork.NewValidater(),
+			fork.NewFilterer(),
+		),
+	}
+}
+
+func (o *otpauth) New(i ...interface{}) fork.Field {
+	var newfield otpauth = *o
+	newfield.URI = ""
+	for _, v := range i {
+		if uri, ok := v.(string); ok {
+			newfield.URI = uri
+		}
+	}
+	newfield.SetValidateable(false)
+	return &newfield
+}
+
+func (o *otpauth) Get() *fork.Value {
+	return fork.NewValue(o.URI)
+}
+
+func (o *otpauth) Set(r *http.Request) {}
+
+type recoveryCodeList struct {
+	*securityName
+	Codes []string
+	fork.Processor
+}
+
+func recoveryCodeListWidget(options ...string) fork.Widget {
+	return fork.NewWidget(fmt.Sprintf(`<ul class="security-recovery-codes" %s>{{ range .Codes }}<li><code>{{ . }}</code></li>{{ end }}</ul>`, strings.Join(options, " ")))
+}
+
+// RecoveryCodeList displays newly generated recovery codes, given to New.
+func RecoveryCodeList(name string, options ...string) fork.Field {
+	return &recoveryCodeList{
+		securityName: &securityName{name},
+		Processor: fork.NewProcessor(
+			recoveryCodeListWidget(options...),
+			fork.NewValidater(),
+			fork.NewFilterer(),
+		),
+	}
+}
+
+func (l *recoveryCodeList) New(i ...interface{}) fork.Field {
+	var newfield recoveryCodeList = *l
+	newfield.Codes = nil
+	for _, v := range i {
+		if codes, ok := v.([]string); ok {
+			newfield.Codes = codes
+		}
+	}
+	newfield.SetValidateable(false)
+	return &newfield
+}
+
+func (l *recoveryCodeList) Get() *fork.Value {
+	return fork.NewValue(l.Codes)
+}
+
+func (l *recoveryCodeList) Set(r *http.Request) {}
+
+type oauthConsent struct {
+	*securityName
+	Client string
+	Scopes []string
+	fork.Processor
+}
+
+func oauthConsentWidget(options ...string) fork.Widget {
+	return fork.NewWidget(fmt.Sprintf(`<div class="security-oauth-consent" %s><p><strong>{{ .Client }}</strong> is asking to access your account{{ if .Scopes }} with:{{ end }}</p>{{ if .Scopes }}<ul>{{ range .Scopes }}<li><code>{{ . }}</code></li>{{ end }}</ul>{{ end }}</div>`, strings.Join(options, " ")))
+}
+
+// OAuthConsent displays the client asking for access and the scopes it asks
+// for, given to New as the client name and a []string of scopes.
+func OAuthConsent(name string, options ...string) fork.Field {
+	return &oauthConsent{
+		securityName: &securityName{name},
+		Processor: fork.NewProcessor(
+			oauthConsentWidget(options...),
+			fork.NewValidater(),
+			fork.NewFilterer(),
+		),
+	}
+}
+
+func (c *oauthConsent) New(i ...interface{}) fork.Field {
+	var newfield oauthConsent = *c
+	newfield.Client, newfield.Scopes = "", nil
+	for _, v := range i {
+		switch val := v.(type) {
+		case string:
+			newfield.Client = val
+		case []string:
+			newfield.Scopes = val
+		}
+	}
+	newfield.SetValidateable(false)
+	return &newfield
+}
+
+func (c *oauthConsent) Get() *fork.Value {
+	return fork.NewValue(c.Client)
+}
+
+func (c *oauthConsent) Set(r *http.Request) {}
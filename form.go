@@ -5,8 +5,10 @@ import (
 	"fmt"
 	"html/template"
 	"strconv"
+	"strings"
 
 	"github.com/thrisp/fork"
+	"github.com/thrisp/security/token"
 	"github.com/thrisp/security/user"
 )
 
@@ -38,8 +40,8 @@ func (s *securityform) Fresh(claims ...interface{}) Form {
 	return &newform
 }
 
-func (s *securityform) expiration() string {
-	return s.m.Times.Expiration("leased_token_duration")
+func (s *securityform) expiration() token.Claims {
+	return token.Claims{"exp": token.NumericDate(s.m.Times.Expires("leased_token_duration"))}
 }
 
 func (s *securityform) signed() fork.Field {
@@ -160,6 +162,25 @@ func formRememberMe(f Form) (bool, string) {
 	return b, s
 }
 
+func formApproved(f Form) (bool, string) {
+	var b bool
+	var s string = "false"
+	v := f.Values()
+	if a, ok := v["approve"]; ok {
+		b = a.Bool()
+		s = strconv.FormatBool(b)
+	}
+	return b, s
+}
+
+func formTwoFactorCode(f Form) string {
+	v := f.Values()
+	if c, ok := v["two-factor-code"]; ok {
+		return strings.Replace(c.String(), " ", "", -1)
+	}
+	return ""
+}
+
 func formNext(f Form) string {
 	var next string
 	v := f.Values()
@@ -188,11 +209,69 @@ func CheckPasswords(f Form) (bool, error) {
 	return true, nil
 }
 
+func formManager(f Form) *Manager {
+	if sf, ok := f.(*securityform); ok {
+		return sf.m
+	}
+	return nil
+}
+
+// formForUser is the email of the account a form was issued for, by the
+// forUser claim of its signed field, as on the anonymous reset form.
+func formForUser(s *Manager, f Form) string {
+	if t, err := s.Signatory("signed").Valid(formSigned(f)); err == nil {
+		return claimString(t.Claims["forUser"])
+	}
+	return ""
+}
+
+func CheckPasswordPolicy(f Form) (bool, error) {
+	s := formManager(f)
+	_, email := formUser(f)
+	if email == "" {
+		email = formForUser(s, f)
+	}
+	if email == "" {
+		email = s.CurrentUser().Email()
+	}
+	if err := s.checkPasswordPolicy(formPassword(f, "confirmable-one"), email); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CheckCurrentPassword authenticates the current user with the password
+// given, for forms changing the account of whoever is logged in.
+func CheckCurrentPassword(f Form) (bool, error) {
+	s := formManager(f)
+	usr := s.CurrentUser()
+	if usr.Anonymous() {
+		return false, MsgError(s, "unauthenticated")
+	}
+	if err := s.authenticate(usr, formPassword(f, "current-pass")); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
 func CheckUserPassword(f Form) (bool, error) {
 	usr, _ := formUser(f)
 	password := formPassword(f, "user-pass")
-	if err := usr.Authenticate(password); err != nil {
+	if err := formManager(f).authenticate(usr, password); err != nil {
 		return false, err
 	}
 	return true, nil
 }
+
+// CheckCredentials applies the login form checks to an email and password
+// given outside of a form, returning the authenticated user.
+func (s *Manager) CheckCredentials(email, password string) (user.User, error) {
+	usr, err := s.activeUser(email)
+	if err != nil {
+		return nil, err
+	}
+	if err = s.authenticate(usr, password); err != nil {
+		return nil, err
+	}
+	return usr, nil
+}
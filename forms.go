@@ -24,6 +24,11 @@ func defaultForms(s *Manager) Forms {
 		"register_form":           RegisterForm(s),
 		"send_confirm_form":       SendConfirmForm(s),
 		"confirm_user_form":       ConfirmUserForm(s),
+		"authorize_form":          AuthorizeForm(s),
+		"two_factor_form":         TwoFactorForm(s),
+		"two_factor_setup_form":   TwoFactorSetupForm(s),
+		"recovery_codes_form":     RecoveryCodesForm(s),
+		"login_code_form":         LoginCodeForm(s),
 	}
 }
 
@@ -77,6 +82,14 @@ func PasswordlessForm(s *Manager) Form {
 	)
 }
 
+func LoginCodeForm(s *Manager) Form {
+	return s.NewForm(
+		"login_code",
+		securityChecks(),
+		fork.TextField("login-code", nil, nil, `placeholder="login code"`, `autocomplete="one-time-code"`),
+	)
+}
+
 func SendResetForm(s *Manager) Form {
 	return s.NewForm(
 		"send_reset",
@@ -88,7 +101,7 @@ func SendResetForm(s *Manager) Form {
 func ResetPasswordForm(s *Manager) Form {
 	return s.NewForm(
 		"reset",
-		securityChecks(CheckPasswords),
+		securityChecks(CheckPasswords, CheckPasswordPolicy),
 		confirmOne,
 		confirmTwo,
 	)
@@ -97,8 +110,8 @@ func ResetPasswordForm(s *Manager) Form {
 func ChangePasswordForm(s *Manager) Form {
 	return s.NewForm(
 		"change",
-		securityChecks(CheckPasswords),
-		UserName(s, "user-name"),
+		securityChecks(CheckCurrentPassword, CheckPasswords, CheckPasswordPolicy),
+		PassWord("current-pass", `placeholder="current password"`),
 		confirmOne,
 		confirmTwo,
 	)
@@ -107,7 +120,7 @@ func ChangePasswordForm(s *Manager) Form {
 func RegisterForm(s *Manager) Form {
 	return s.NewForm(
 		"register",
-		securityChecks(CheckPasswords),
+		securityChecks(CheckPasswords, CheckPasswordPolicy),
 		NewUserName(s, "user-name"),
 		confirmOne,
 		confirmTwo,
@@ -125,9 +138,43 @@ func SendConfirmForm(s *Manager) Form {
 func ConfirmUserForm(s *Manager) Form {
 	return s.NewForm(
 		"confirm_user",
-		securityChecks(CheckPasswords),
+		securityChecks(CheckPasswords, CheckPasswordPolicy),
 		UserName(s, "user-name"),
 		confirmOne,
 		confirmTwo,
 	)
 }
+
+func AuthorizeForm(s *Manager) Form {
+	return s.NewForm(
+		"authorize",
+		securityChecks(),
+		OAuthConsent("consent"),
+		fork.BooleanField("approve", "Allow access to your account", false),
+	)
+}
+
+func TwoFactorForm(s *Manager) Form {
+	return s.NewForm(
+		"two_factor",
+		securityChecks(),
+		TwoFactorCode("two-factor-code"),
+	)
+}
+
+func TwoFactorSetupForm(s *Manager) Form {
+	return s.NewForm(
+		"two_factor_setup",
+		securityChecks(),
+		OTPAuth("otpauth"),
+		TwoFactorCode("two-factor-code"),
+	)
+}
+
+func RecoveryCodesForm(s *Manager) Form {
+	return s.NewForm(
+		"recovery_codes",
+		securityChecks(),
+		RecoveryCodeList("recovery-codes"),
+	)
+}
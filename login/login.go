@@ -99,6 +99,12 @@ func (l *Manager) LoginUser(u user.User, remember bool) bool {
 	return true
 }
 
+// LoginRequest makes u the current user for the current request only, as
+// for stateless API authentication; no user token or remember cookie is set.
+func (l *Manager) LoginRequest(u user.User) {
+	l.s.Set("user", u)
+}
+
 func (l *Manager) LogoutUser() bool {
 	l.s.Delete("user")
 	l.s.Delete("user_token")
@@ -0,0 +1,147 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+)
+
+const loginCodeDigits = 6
+
+// GenerateLoginCode returns a random numeric code for logging in by email.
+func GenerateLoginCode() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%0*d", loginCodeDigits, n.Int64())
+}
+
+// loginCodeMAC keys the code to this site, so the pending login kept in the
+// session does not give the code away.
+func (s *Manager) loginCodeMAC(nonce, code string) string {
+	mac := hmac.New(sha256.New, []byte(s.secret("login_code")))
+	mac.Write([]byte(nonce + ":" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sendLoginCode emails a login code in place of a passwordless link, keeping
+// the pending login in the session of the browser that asked for it.
+func (s *Manager) sendLoginCode(f flotilla.Ctx, email string, claims token.Claims) error {
+	code, nonce := GenerateLoginCode(), token.NewTokenID()
+	claims["cn"] = nonce
+	claims["code"] = s.loginCodeMAC(nonce, code)
+	f.Call("setsession", "login_code", s.Token("login_code", claims))
+	data := s.emailData(email, "")
+	data["Code"] = code
+	b, err := s.Emailer.Render("passwordless_code", data)
+	if err != nil {
+		return err
+	}
+	return s.Emailer.Send(email, b.Bytes())
+}
+
+// pendingLoginCode is the login awaiting a code in the session, kept there
+// until the code is accepted, expires or runs out of attempts.
+func (s *Manager) pendingLoginCode(f flotilla.Ctx) *token.Token {
+	p, _ := f.Call("getsession", "login_code")
+	pending, _ := p.(string)
+	if pending == "" {
+		return nil
+	}
+	tkn, err := s.Signatory("login_code").Valid(pending)
+	if err != nil {
+		return nil
+	}
+	return tkn
+}
+
+func (s *Manager) loginCodeAttempts() int {
+	n, err := strconv.Atoi(s.Setting("passwordless_code_attempts"))
+	if err != nil || n < 1 {
+		return 1
+	}
+	return n
+}
+
+// checkLoginCode reports whether code is the one sent for the pending login,
+// accepting it once and not after the allowed attempts are used up.
+func (s *Manager) checkLoginCode(tkn *token.Token, code string) bool {
+	nonce := claimString(tkn.Claims["cn"])
+	if s.attemptsSpent("login_code:"+nonce, s.loginCodeAttempts()) {
+		return false
+	}
+	if !hmac.Equal([]byte(s.loginCodeMAC(nonce, code)), []byte(claimString(tkn.Claims["code"]))) {
+		return false
+	}
+	fresh, err := s.tokens.Spend("login_code:"+nonce, s.Expires("passwordless_duration"))
+	return fresh && err == nil
+}
+
+// failLoginCode records a failed attempt at the pending login code, reporting
+// whether any attempts remain.
+func (s *Manager) failLoginCode(tkn *token.Token) bool {
+	nonce := claimString(tkn.Claims["cn"])
+	return s.spendAttempt("login_code:"+nonce, s.loginCodeAttempts(), s.Expires("passwordless_duration"))
+}
+
+func (s *Manager) loginCodeExpired(f flotilla.Ctx, message string) {
+	f.Call("deletesession", "login_code")
+	s.Flash(f, message)
+	f.Call("redirect", 303, s.BlueprintUrl("passwordless_url"))
+}
+
+func formLoginCode(f Form) string {
+	v := f.Values()
+	if c, ok := v["login-code"]; ok {
+		return strings.Replace(c.String(), " ", "", -1)
+	}
+	return ""
+}
+
+func getLoginCode(f flotilla.Ctx) {
+	s := manager(f)
+	if s.pendingLoginCode(f) == nil {
+		s.loginCodeExpired(f, "login_code_expired")
+		return
+	}
+	f.Call("rendertemplate", "login_code.html", nil)
+}
+
+func postLoginCode(f flotilla.Ctx) {
+	posted(
+		f,
+		"login_code",
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			tkn := s.pendingLoginCode(f)
+			if tkn == nil {
+				s.loginCodeExpired(f, "login_code_expired")
+				return
+			}
+			if !s.checkLoginCode(tkn, formLoginCode(form)) {
+				if !s.failLoginCode(tkn) {
+					s.loginCodeExpired(f, "login_code_attempts")
+					return
+				}
+				f.Call("set", form.Tag(), form)
+				s.forwardTo(f, "login_code.html", "invalid_login_code")
+				return
+			}
+			f.Call("deletesession", "login_code")
+			usr, remember := validUserToken(s, tkn)
+			if usr == nil {
+				s.forwardTo(f, "passwordless_login.html", "invalid_login_token")
+				return
+			}
+			s.loginOrPend(f, usr, remember, s.nxtAbsolute(request(f), form))
+		},
+	)
+}
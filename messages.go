@@ -29,22 +29,47 @@ var defaultMessages map[string]Message = map[string]Message{
 	"login_expired":              Msg("You did not login within %s. New instructions to login have been sent to %s.", "error"),
 	"login_email_sent":           Msg("Instructions to login have been sent to the provided email address.", "success"),
 	"invalid_login_token":        Msg("Invalid login token.", "error"),
+	"login_code_sent":            Msg("A login code has been sent to the provided email address.", "success"),
+	"invalid_login_code":         Msg("Invalid login code.", "error"),
+	"login_code_expired":         Msg("Your login code has expired, please request another.", "error"),
+	"login_code_attempts":        Msg("Too many incorrect login codes, please request another.", "error"),
 	"disabled_account":           Msg("Account is disabled.", "error"),
+	"account_locked":             Msg("Account is locked after too many failed attempts, try again in %s or use the unlock link sent to its email address.", "error"),
+	"too_many_attempts":          Msg("Too many failed attempts, try again in %s.", "error"),
+	"account_unlocked":           Msg("Your account has been unlocked.", "success"),
+	"invalid_unlock_token":       Msg("Invalid unlock token.", "error"),
 	"email_not_provided":         Msg("Email not provided", "error"),
 	"invalid_email_address":      Msg("Invalid email address", "error"),
 	"password_not_provided":      Msg("Password not provided", "error"),
 	"password_not_set":           Msg("No password is set for this user", "error"),
-	"password_invalid_length":    Msg("Password must be at least 6 characters", "error"),
+	"password_invalid_length":    Msg("Password must be at least %s characters", "error"),
+	"password_too_long":          Msg("Password must be at most %s characters", "error"),
+	"password_requires_upper":    Msg("Password must contain an uppercase letter", "error"),
+	"password_requires_lower":    Msg("Password must contain a lowercase letter", "error"),
+	"password_requires_digit":    Msg("Password must contain a digit", "error"),
+	"password_requires_symbol":   Msg("Password must contain a symbol", "error"),
+	"password_contains_email":    Msg("Password must not contain your email address", "error"),
+	"password_too_weak":          Msg("Password is too easy to guess", "error"),
+	"password_breached":          Msg("Password has appeared in a data breach, please choose another", "error"),
 	"user_does_not_exist":        Msg("Specified user does not exist", "error"),
 	"invalid_password":           Msg("Invalid password", "error"),
 	"reset_successful":           Msg("Your password has been reset successfully and you have been logged in.", "success"),
-	"password_is_the_same":       Msg("Your new password must be different than your previous password.", "error"),
+	"password_is_the_same":       Msg("Your new password must be different than your recent passwords.", "error"),
 	"password_change":            Msg("You successfully changed your password.", "success"),
 	"login":                      Msg("Please log in to access this page.", "info"),
 	"refresh":                    Msg("Please reauthenticate to access this page.", "info"),
 	"login_successful":           Msg("You have been successfully logged in.", "success"),
 	"passwordless_login_success": Msg("You have successfuly logged in.", "success"),
 	"logout_successful":          Msg("You have been successfully logged out.", "success"),
+	"oidc_login_failed":          Msg("Could not log in with the external provider.", "error"),
+	"two_factor_required":        Msg("Enter the code from your authenticator app to finish logging in.", "info"),
+	"invalid_two_factor_code":    Msg("Invalid authentication code.", "error"),
+	"two_factor_expired":         Msg("You did not enter an authentication code in time, please log in again.", "error"),
+	"two_factor_attempts":        Msg("Too many invalid authentication codes, please log in again.", "error"),
+	"two_factor_enabled":         Msg("Two-factor authentication is enabled.", "success"),
+	"recovery_codes_generated":   Msg("Keep these recovery codes somewhere safe, each can be used once. Any previous codes no longer work.", "success"),
+	"passkey_registered":         Msg("Your passkey has been registered.", "success"),
+	"recovery_code_used":         Msg("You logged in with a recovery code, %s remain.", "info"),
 }
 
 type Messages map[string]Message
@@ -0,0 +1,322 @@
+package security
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+)
+
+// OAuthClient is a client application registered with the authorization
+// server. Clients without a Secret are public, e.g. mobile and single page
+// apps, and can only use the authorization_code grant.
+type OAuthClient struct {
+	ID           string
+	Secret       string
+	Name         string
+	RedirectURIs []string
+	Scopes       []string
+}
+
+// displayName is the client Name shown when asking for consent, or its ID.
+func (c *OAuthClient) displayName() string {
+	if c.Name != "" {
+		return c.Name
+	}
+	return c.ID
+}
+
+func (c *OAuthClient) Public() bool {
+	return c.Secret == ""
+}
+
+func (c *OAuthClient) authenticate(secret string) bool {
+	return !c.Public() && subtle.ConstantTimeCompare([]byte(c.Secret), []byte(secret)) == 1
+}
+
+// redirect returns the registered redirect uri matching uri exactly, or the
+// only registered uri when uri is empty.
+func (c *OAuthClient) redirect(uri string) (string, bool) {
+	if uri == "" && len(c.RedirectURIs) == 1 {
+		return c.RedirectURIs[0], true
+	}
+	for _, r := range c.RedirectURIs {
+		if r == uri {
+			return r, true
+		}
+	}
+	return "", false
+}
+
+// allows reports whether every space separated scope was registered.
+func (c *OAuthClient) allows(scope string) bool {
+	for _, sc := range strings.Fields(scope) {
+		if !existsIn(sc, c.Scopes...) {
+			return false
+		}
+	}
+	return true
+}
+
+// OAuthClients is the registry of clients known to the authorization server.
+type OAuthClients interface {
+	Client(id string) *OAuthClient
+}
+
+type oauthClients map[string]*OAuthClient
+
+// NewOAuthClients returns an in-memory OAuthClients of clients.
+func NewOAuthClients(clients ...*OAuthClient) OAuthClients {
+	oc := make(oauthClients)
+	for _, c := range clients {
+		oc[c.ID] = c
+	}
+	return oc
+}
+
+func (o oauthClients) Client(id string) *OAuthClient {
+	return o[id]
+}
+
+func (s *Manager) oauthClient(id string) *OAuthClient {
+	if s.clients == nil || id == "" {
+		return nil
+	}
+	return s.clients.Client(id)
+}
+
+// pkceS256 is the S256 code challenge of verifier.
+func pkceS256(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func redirectWith(uri string, params map[string]string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return uri
+	}
+	q := u.Query()
+	for k, v := range params {
+		if v != "" {
+			q.Set(k, v)
+		}
+	}
+	u.RawQuery = q.Encode()
+	return u.String()
+}
+
+func redirectError(f flotilla.Ctx, uri, state, code, description string) {
+	f.Call("redirect", 302, redirectWith(uri, map[string]string{
+		"error":             code,
+		"error_description": description,
+		"state":             state,
+	}))
+}
+
+// rememberAuthorize keeps an unauthenticated authorization request in the
+// session, so postLogin can resume it after LoginRequired sends the user to
+// log in.
+func rememberAuthorize(h flotilla.Manage) flotilla.Manage {
+	return func(f flotilla.Ctx) {
+		s := manager(f)
+		if !s.CurrentUser().Authenticated() {
+			f.Call("setsession", "oauth_authorize", request(f).URL.RequestURI())
+		}
+		h(f)
+	}
+}
+
+// resumeAuthorize returns, and forgets, an authorization request kept by
+// rememberAuthorize.
+func resumeAuthorize(f flotilla.Ctx) string {
+	if a, _ := f.Call("getsession", "oauth_authorize"); a != nil {
+		f.Call("deletesession", "oauth_authorize")
+		if uri, ok := a.(string); ok {
+			return uri
+		}
+	}
+	return ""
+}
+
+func getAuthorize(f flotilla.Ctx) {
+	s, q := manager(f), request(f).URL.Query()
+	client := s.oauthClient(q.Get("client_id"))
+	if client == nil {
+		f.Call("serveplain", 400, "unknown client_id")
+		return
+	}
+	uri, ok := client.redirect(q.Get("redirect_uri"))
+	if !ok {
+		f.Call("serveplain", 400, "redirect_uri is not registered for this client")
+		return
+	}
+	state := q.Get("state")
+	if q.Get("response_type") != "code" {
+		redirectError(f, uri, state, "unsupported_response_type", "only the code response type is supported")
+		return
+	}
+	if q.Get("code_challenge") == "" || q.Get("code_challenge_method") != "S256" {
+		redirectError(f, uri, state, "invalid_request", "a PKCE S256 code_challenge is required")
+		return
+	}
+	if !client.allows(q.Get("scope")) {
+		redirectError(f, uri, state, "invalid_scope", "scope is not registered for this client")
+		return
+	}
+	form := s.Forms.byKey("authorize").Fresh(token.Claims{
+		"ut":    s.CurrentUser().Token("authorize"),
+		"cid":   client.ID,
+		"ruri":  uri,
+		"scope": q.Get("scope"),
+		"state": state,
+		"cc":    q.Get("code_challenge"),
+	})
+	form.Fields(OAuthConsent("consent").New(client.displayName(), strings.Fields(q.Get("scope"))))
+	f.Call("set", form.Tag(), form)
+	f.Call("rendertemplate", "authorize.html", nil)
+}
+
+func postAuthorize(f flotilla.Ctx) {
+	posted(
+		f,
+		"authorize",
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			usr := s.CurrentUser()
+			t, err := s.Signatory("signed").Consume(formSigned(form))
+			if err != nil || claimString(t.Claims["ut"]) != usr.Token("authorize") {
+				s.formFail(f, form, "authorize.html")
+				return
+			}
+			uri, state := claimString(t.Claims["ruri"]), claimString(t.Claims["state"])
+			if approved, _ := formApproved(form); !approved {
+				redirectError(f, uri, state, "access_denied", "the user denied access")
+				return
+			}
+			code := s.Token("oauth_code", token.Claims{
+				"ut":    usr.Token("oauth_code"),
+				"cid":   t.Claims["cid"],
+				"ruri":  uri,
+				"scope": t.Claims["scope"],
+				"cc":    t.Claims["cc"],
+				"exp":   token.NumericDate(s.Expires("oauth_code_duration")),
+			})
+			f.Call("redirect", 302, redirectWith(uri, map[string]string{"code": code, "state": state}))
+		},
+	)
+}
+
+// oauthAuthenticate identifies the client of a token request by HTTP Basic
+// authentication or client_id and client_secret parameters. Public clients
+// give their client_id only.
+func (s *Manager) oauthAuthenticate(r *http.Request, p map[string]string) (*OAuthClient, bool) {
+	id, secret, basic := r.BasicAuth()
+	if !basic {
+		id, secret = p["client_id"], p["client_secret"]
+	}
+	client := s.oauthClient(id)
+	if client == nil {
+		return nil, false
+	}
+	if client.Public() {
+		return client, secret == ""
+	}
+	return client, client.authenticate(secret)
+}
+
+func postOAuthToken(f flotilla.Ctx) {
+	s, r := manager(f), request(f)
+	p := apiParams(r)
+	client, ok := s.oauthAuthenticate(r, p)
+	if !ok {
+		serveAPIError(f, 401, "invalid_client", "client authentication failed")
+		return
+	}
+	switch p["grant_type"] {
+	case "authorization_code":
+		s.grantAuthorizationCode(f, client, p)
+	case "refresh_token":
+		resp, err := s.refreshTokens(p["refresh_token"], client.ID)
+		if err != nil {
+			serveAPIError(f, 400, "invalid_grant", err.Error())
+			return
+		}
+		serveJSON(f, 200, resp)
+	case "client_credentials":
+		s.grantClientCredentials(f, client, p)
+	default:
+		serveAPIError(f, 400, "unsupported_grant_type", "grant_type is not supported")
+	}
+}
+
+func (s *Manager) grantAuthorizationCode(f flotilla.Ctx, client *OAuthClient, p map[string]string) {
+	t, err := s.Signatory("oauth_code").Consume(p["code"])
+	if err != nil {
+		serveAPIError(f, 400, "invalid_grant", "invalid authorization code")
+		return
+	}
+	if claimString(t.Claims["cid"]) != client.ID || claimString(t.Claims["ruri"]) != p["redirect_uri"] {
+		serveAPIError(f, 400, "invalid_grant", "authorization code was issued to another client or redirect_uri")
+		return
+	}
+	cc := claimString(t.Claims["cc"])
+	if subtle.ConstantTimeCompare([]byte(cc), []byte(pkceS256(p["code_verifier"]))) != 1 {
+		serveAPIError(f, 400, "invalid_grant", "code_verifier does not match the code_challenge")
+		return
+	}
+	usr, _ := validUserToken(s, t)
+	if usr == nil || !usr.Active() {
+		serveAPIError(f, 400, "invalid_grant", "invalid authorization code")
+		return
+	}
+	scope := claimString(t.Claims["scope"])
+	resp := s.issueTokens(usr, token.NewTokenID(), oauthClaims(client, scope))
+	resp["scope"] = scope
+	serveJSON(f, 200, resp)
+}
+
+func (s *Manager) grantClientCredentials(f flotilla.Ctx, client *OAuthClient, p map[string]string) {
+	if client.Public() {
+		serveAPIError(f, 400, "unauthorized_client", "public clients cannot use client_credentials")
+		return
+	}
+	if !client.allows(p["scope"]) {
+		serveAPIError(f, 400, "invalid_scope", "scope is not registered for this client")
+		return
+	}
+	claims := oauthClaims(client, p["scope"])
+	claims["sub"] = client.ID
+	claims["exp"] = token.NumericDate(s.Expires("access_duration"))
+	serveJSON(f, 200, map[string]interface{}{
+		"access_token": s.Token("oauth_access", claims),
+		"token_type":   "Bearer",
+		"expires_in":   s.expiresIn("access_duration"),
+		"scope":        p["scope"],
+	})
+}
+
+func oauthClaims(client *OAuthClient, scope string) token.Claims {
+	return token.Claims{"cid": client.ID, "scope": scope}
+}
+
+// OAuthToken returns the valid OAuth access token of an Authorization:
+// Bearer header. OAuth access tokens are minted by the oauth_access
+// signatory, which bearer authentication never accepts, so routes serving
+// clients check the token and its OAuthScope themselves.
+func (s *Manager) OAuthToken(f flotilla.Ctx) (*token.Token, error) {
+	tkn, err := s.Signatory("oauth_access").Valid(bearerToken(request(f)))
+	if err == nil && s.familyRevoked(tkn) {
+		return nil, accessRevoked
+	}
+	return tkn, err
+}
+
+// OAuthScope returns the client and scope an access token was issued for.
+func OAuthScope(t *token.Token) (clientID string, scope []string) {
+	return claimString(t.Claims["cid"]), strings.Fields(claimString(t.Claims["scope"]))
+}
@@ -0,0 +1,280 @@
+package security
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
+)
+
+var (
+	OIDCDiscoveryFailed = SecurityError("OpenID Connect discovery failed: %s").Out
+	OIDCExchangeFailed  = SecurityError("OpenID Connect code exchange failed: %s").Out
+	OIDCInvalidIDToken  = SecurityError("invalid OpenID Connect ID token: %s").Out
+)
+
+// OIDCProvider is an external OpenID Connect issuer users may log in with.
+// Endpoints left empty are discovered from the issuer's
+// /.well-known/openid-configuration document on first use.
+type OIDCProvider struct {
+	// Name identifies the provider in the OIDC_LOGIN_URL, e.g. /oidc/login/google.
+	Name                  string
+	Issuer                string
+	ClientID              string
+	ClientSecret          string
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JWKSURI               string
+	// Scopes requested in addition to openid; email by default.
+	Scopes []string
+	// Algorithms accepted for ID tokens; RS256 by default.
+	Algorithms []string
+	// TrustEmail accepts email claims the issuer has not marked verified.
+	TrustEmail bool
+	// Client makes requests to the issuer, http.DefaultClient when nil.
+	Client *http.Client
+
+	mu      sync.Mutex
+	keyfunc token.Keyfunc
+}
+
+// SubjectStore is a user.DataStore able to find users by their subject at an
+// OpenID Connect issuer. Without one, users are found by email.
+type SubjectStore interface {
+	Subject(issuer, subject string) user.User
+}
+
+func (p *OIDCProvider) client() *http.Client {
+	if p.Client == nil {
+		return http.DefaultClient
+	}
+	return p.Client
+}
+
+// configure discovers any missing endpoints, retrying on later requests
+// when the issuer could not be reached.
+func (p *OIDCProvider) configure() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.keyfunc != nil {
+		return nil
+	}
+	if p.AuthorizationEndpoint == "" || p.TokenEndpoint == "" || p.JWKSURI == "" {
+		if err := p.discover(); err != nil {
+			return err
+		}
+	}
+	p.keyfunc = token.JWKSKeyfunc(token.JWKSURL(p.JWKSURI, p.client()))
+	return nil
+}
+
+func (p *OIDCProvider) discover() error {
+	resp, err := p.client().Get(strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return OIDCDiscoveryFailed(err.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return OIDCDiscoveryFailed(resp.Status)
+	}
+	var doc struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JWKSURI               string `json:"jwks_uri"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return OIDCDiscoveryFailed(err.Error())
+	}
+	if doc.Issuer != p.Issuer {
+		return OIDCDiscoveryFailed("issuer " + doc.Issuer + " does not match " + p.Issuer)
+	}
+	if p.AuthorizationEndpoint == "" {
+		p.AuthorizationEndpoint = doc.AuthorizationEndpoint
+	}
+	if p.TokenEndpoint == "" {
+		p.TokenEndpoint = doc.TokenEndpoint
+	}
+	if p.JWKSURI == "" {
+		p.JWKSURI = doc.JWKSURI
+	}
+	return nil
+}
+
+func (p *OIDCProvider) authorizeURL(redirect, state, nonce, verifier string) string {
+	scopes := append([]string{"openid"}, p.Scopes...)
+	if len(p.Scopes) == 0 {
+		scopes = append(scopes, "email")
+	}
+	return redirectWith(p.AuthorizationEndpoint, map[string]string{
+		"response_type":         "code",
+		"client_id":             p.ClientID,
+		"redirect_uri":          redirect,
+		"scope":                 strings.Join(scopes, " "),
+		"state":                 state,
+		"nonce":                 nonce,
+		"code_challenge":        pkceS256(verifier),
+		"code_challenge_method": "S256",
+	})
+}
+
+// exchange trades an authorization code for the ID token at the token
+// endpoint.
+func (p *OIDCProvider) exchange(code, redirect, verifier string) (string, error) {
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirect},
+		"code_verifier": {verifier},
+		"client_id":     {p.ClientID},
+	}
+	rq, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return "", OIDCExchangeFailed(err.Error())
+	}
+	rq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if p.ClientSecret != "" {
+		rq.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+	resp, err := p.client().Do(rq)
+	if err != nil {
+		return "", OIDCExchangeFailed(err.Error())
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	json.NewDecoder(resp.Body).Decode(&body)
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", OIDCExchangeFailed(resp.Status + " " + body.Error)
+	}
+	return body.IDToken, nil
+}
+
+// validate verifies the signature, issuer, audience and nonce of an ID token.
+func (p *OIDCProvider) validate(idToken, nonce string) (*token.Token, error) {
+	algs := p.Algorithms
+	if len(algs) == 0 {
+		algs = []string{"RS256"}
+	}
+	tkn, err := token.ParseWithOptions(idToken, p.keyfunc, &token.ValidationOptions{
+		Issuer:     p.Issuer,
+		Audience:   p.ClientID,
+		Algorithms: algs,
+		Required:   []string{"sub", "exp", "iat"},
+	})
+	if err != nil {
+		return nil, OIDCInvalidIDToken(err.Error())
+	}
+	if claimString(tkn.Claims["nonce"]) != nonce {
+		return nil, OIDCInvalidIDToken("nonce mismatch")
+	}
+	return tkn, nil
+}
+
+var OIDCUnverifiedEmail = SecurityError("OpenID Connect email is missing or unverified")
+
+// oidcUser maps the subject or verified email of an ID token to a user,
+// registering one when REGISTERABLE is set.
+func (s *Manager) oidcUser(p *OIDCProvider, tkn *token.Token) (user.User, error) {
+	if ss, ok := s.DataStore.(SubjectStore); ok {
+		if usr := ss.Subject(p.Issuer, claimString(tkn.Claims["sub"])); usr != nil && !usr.Anonymous() {
+			return usr, nil
+		}
+	}
+	email := claimString(tkn.Claims["email"])
+	verified := claimBool(tkn.Claims["email_verified"])
+	if email == "" || !(verified || p.TrustEmail) {
+		return nil, OIDCUnverifiedEmail
+	}
+	if usr := s.Get(email); !usr.Anonymous() {
+		return usr, nil
+	}
+	if !s.BoolSetting("registerable") {
+		return nil, MsgError(s, "user_does_not_exist")
+	}
+	usr, err := s.newUser(email, token.NewTokenID())
+	if err != nil {
+		return nil, err
+	}
+	if verified {
+		usr.Confirm()
+	}
+	return usr, nil
+}
+
+func (s *Manager) oidcFail(f flotilla.Ctx) {
+	s.Flash(f, "oidc_login_failed")
+	f.Call("redirect", 303, s.BlueprintUrl(s.ManagerLogin()))
+}
+
+func getOIDCLogin(f flotilla.Ctx) {
+	s := manager(f)
+	p := s.providers[tokenFromUrl(f, "provider")]
+	if p == nil {
+		f.Call("status", 404)
+		return
+	}
+	if err := p.configure(); err != nil {
+		s.oidcFail(f)
+		return
+	}
+	nonce, verifier := token.NewTokenID(), token.NewTokenID()+token.NewTokenID()
+	state := s.Token("oidc_state", token.Claims{
+		"prov":  p.Name,
+		"nonce": nonce,
+		"exp":   token.NumericDate(s.Expires("oidc_state_duration")),
+	})
+	f.Call("setsession", "oidc_nonce", nonce)
+	f.Call("setsession", "oidc_verifier", verifier)
+	f.Call("redirect", 302, p.authorizeURL(s.External(f, "getOIDCCallback"), state, nonce, verifier))
+}
+
+func sessionString(f flotilla.Ctx, key string) string {
+	v, _ := f.Call("getsession", key)
+	f.Call("deletesession", key)
+	s, _ := v.(string)
+	return s
+}
+
+func getOIDCCallback(f flotilla.Ctx) {
+	s, q := manager(f), request(f).URL.Query()
+	nonce, verifier := sessionString(f, "oidc_nonce"), sessionString(f, "oidc_verifier")
+	state, err := s.Signatory("oidc_state").Consume(q.Get("state"))
+	if err != nil || nonce == "" || claimString(state.Claims["nonce"]) != nonce || q.Get("code") == "" {
+		s.oidcFail(f)
+		return
+	}
+	p := s.providers[claimString(state.Claims["prov"])]
+	if p == nil || p.configure() != nil {
+		s.oidcFail(f)
+		return
+	}
+	idToken, err := p.exchange(q.Get("code"), s.External(f, "getOIDCCallback"), verifier)
+	if err != nil {
+		s.oidcFail(f)
+		return
+	}
+	tkn, err := p.validate(idToken, nonce)
+	if err != nil {
+		s.oidcFail(f)
+		return
+	}
+	usr, err := s.oidcUser(p, tkn)
+	if err != nil {
+		s.oidcFail(f)
+		return
+	}
+	if !usr.Active() {
+		s.Flash(f, "disabled_account")
+		f.Call("redirect", 303, s.BlueprintUrl(s.ManagerLogin()))
+		return
+	}
+	s.loginOrPend(f, usr, false, s.BlueprintUrl("after_login_url"))
+}
@@ -0,0 +1,204 @@
+package security
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
+	"github.com/thrisp/security/webauthn"
+)
+
+// relyingParty describes this site to authenticators. PASSKEY_RP_ID defaults
+// to the request host, and PASSKEY_ORIGINS, a comma separated list, to the
+// https origin of the relying party id.
+func (s *Manager) relyingParty(f flotilla.Ctx) *webauthn.RelyingParty {
+	id := s.Setting("passkey_rp_id")
+	if id == "" {
+		id = request(f).Host
+		if h, _, err := net.SplitHostPort(id); err == nil {
+			id = h
+		}
+	}
+	name := s.Setting("passkey_rp_name")
+	if name == "" {
+		name = id
+	}
+	var origins []string
+	for _, o := range strings.Split(s.Setting("passkey_origins"), ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			origins = append(origins, o)
+		}
+	}
+	if len(origins) == 0 {
+		origins = []string{"https://" + id}
+	}
+	return &webauthn.RelyingParty{
+		ID:               id,
+		Name:             name,
+		Origins:          origins,
+		UserVerification: s.BoolSetting("passkey_user_verification"),
+	}
+}
+
+// beginCeremony keeps a new challenge in the session for the ceremony that
+// follows, with claims identifying whose it is.
+func (s *Manager) beginCeremony(f flotilla.Ctx, claims token.Claims) string {
+	challenge := webauthn.NewChallenge()
+	claims["ch"] = challenge
+	claims["exp"] = token.NumericDate(s.Expires("passkey_duration"))
+	f.Call("setsession", "passkey", s.Token("passkey", claims))
+	return challenge
+}
+
+// ceremony returns, once, the challenge kept by beginCeremony.
+func (s *Manager) ceremony(f flotilla.Ctx) *token.Token {
+	kept := sessionString(f, "passkey")
+	if kept == "" {
+		return nil
+	}
+	tkn, err := s.Signatory("passkey").Consume(kept)
+	if err != nil {
+		return nil
+	}
+	return tkn
+}
+
+type passkeyCredential struct {
+	ID       string `json:"id"`
+	Response struct {
+		ClientDataJSON    string `json:"clientDataJSON"`
+		AttestationObject string `json:"attestationObject"`
+		AuthenticatorData string `json:"authenticatorData"`
+		Signature         string `json:"signature"`
+	} `json:"response"`
+}
+
+// passkeyBody reads the JSON encoded PublicKeyCredential of a ceremony,
+// binary values base64url encoded, into id, clientDataJSON and the
+// attestation object or authenticator data and signature.
+func passkeyBody(f flotilla.Ctx) (map[string][]byte, error) {
+	var pc passkeyCredential
+	if err := json.NewDecoder(request(f).Body).Decode(&pc); err != nil {
+		return nil, webauthn.ErrMalformed
+	}
+	ret := make(map[string][]byte)
+	for k, v := range map[string]string{
+		"id":                pc.ID,
+		"clientDataJSON":    pc.Response.ClientDataJSON,
+		"attestationObject": pc.Response.AttestationObject,
+		"authenticatorData": pc.Response.AuthenticatorData,
+		"signature":         pc.Response.Signature,
+	} {
+		b, err := webauthn.Encoding.DecodeString(strings.TrimRight(v, "="))
+		if err != nil {
+			return nil, webauthn.ErrMalformed
+		}
+		ret[k] = b
+	}
+	return ret, nil
+}
+
+func getPasskeyRegister(f flotilla.Ctx) {
+	s := manager(f)
+	usr := s.CurrentUser()
+	pk, ok := usr.(user.Passkeys)
+	if !ok {
+		f.Call("status", 404)
+		return
+	}
+	challenge := s.beginCeremony(f, token.Claims{"ut": usr.Token("passkey")})
+	options := s.relyingParty(f).CreationOptions(challenge, []byte(usr.Id()), usr.Email(), usr.Email(), pk.Passkeys())
+	serveJSON(f, 200, map[string]interface{}{"publicKey": options})
+}
+
+func postPasskeyRegister(f flotilla.Ctx) {
+	s := manager(f)
+	usr := s.CurrentUser()
+	pk, ok := usr.(user.Passkeys)
+	kept := s.ceremony(f)
+	if !ok || kept == nil || !usr.Validate("passkey", claimString(kept.Claims["ut"])) {
+		serveAPIError(f, 400, "invalid_request", "no passkey registration was begun")
+		return
+	}
+	body, err := passkeyBody(f)
+	if err != nil {
+		serveAPIError(f, 400, "invalid_request", err.Error())
+		return
+	}
+	cred, err := s.relyingParty(f).VerifyRegistration(claimString(kept.Claims["ch"]), body["clientDataJSON"], body["attestationObject"])
+	if err == nil {
+		err = pk.SavePasskey(cred)
+	}
+	if err == nil {
+		_, err = s.Put(usr)
+	}
+	if err != nil {
+		serveAPIError(f, 400, "invalid_credential", err.Error())
+		return
+	}
+	s.Flash(f, "passkey_registered")
+	serveJSON(f, 200, map[string]string{"id": webauthn.Encoding.EncodeToString(cred.ID)})
+}
+
+// getPasskeyLogin begins logging in the user given by the email query
+// parameter. Unknown users get options without credentials, so they cannot
+// be told apart.
+func getPasskeyLogin(f flotilla.Ctx) {
+	s := manager(f)
+	email := request(f).URL.Query().Get("email")
+	var allow []*webauthn.Credential
+	if pk, ok := s.Get(email).(user.Passkeys); ok {
+		allow = pk.Passkeys()
+	}
+	challenge := s.beginCeremony(f, token.Claims{"email": email})
+	serveJSON(f, 200, map[string]interface{}{"publicKey": s.relyingParty(f).RequestOptions(challenge, allow)})
+}
+
+func postPasskeyLogin(f flotilla.Ctx) {
+	s := manager(f)
+	kept := s.ceremony(f)
+	if kept == nil {
+		serveAPIError(f, 400, "invalid_request", "no passkey login was begun")
+		return
+	}
+	body, err := passkeyBody(f)
+	if err != nil {
+		serveAPIError(f, 400, "invalid_request", err.Error())
+		return
+	}
+	usr, err := s.activeUser(claimString(kept.Claims["email"]))
+	pk, ok := usr.(user.Passkeys)
+	if err != nil || !ok {
+		serveAPIError(f, 401, "invalid_credential", "passkey login failed")
+		return
+	}
+	cred := webauthn.FindCredential(pk.Passkeys(), body["id"])
+	if cred == nil {
+		serveAPIError(f, 401, "invalid_credential", "passkey login failed")
+		return
+	}
+	count, err := s.relyingParty(f).VerifyAssertion(claimString(kept.Claims["ch"]), cred, body["clientDataJSON"], body["authenticatorData"], body["signature"])
+	if err != nil {
+		serveAPIError(f, 401, "invalid_credential", err.Error())
+		return
+	}
+	updated := *cred
+	updated.SignCount = count
+	if err = pk.SavePasskey(&updated); err == nil {
+		_, err = s.Put(usr)
+	}
+	if err != nil {
+		serveAPIError(f, 500, "server_error", err.Error())
+		return
+	}
+	s.LoginUser(usr, false, f)
+	next := s.BlueprintUrl("after_login_url")
+	if authorize := resumeAuthorize(f); authorize != "" {
+		next = authorize
+	}
+	s.Flash(f, "login_successful")
+	serveJSON(f, 200, map[string]string{"redirect": next})
+}
@@ -0,0 +1,461 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
+)
+
+// PasswordHasher hashes passwords into an encoded form recording the
+// algorithm and parameters used, so a hash can be verified after the
+// parameters change.
+type PasswordHasher interface {
+	// Identifies reports whether encoded was made by this algorithm.
+	Identifies(encoded string) bool
+	Hash(password string) (string, error)
+	Verify(password, encoded string) (bool, error)
+	// NeedsRehash reports whether encoded was made with other parameters
+	// than the hasher's own.
+	NeedsRehash(encoded string) bool
+}
+
+var (
+	UnknownPasswordHash   = SecurityError("password hash algorithm is not recognized")
+	MalformedPasswordHash = SecurityError("password hash is malformed")
+)
+
+const (
+	passwordSaltLength = 16
+	passwordKeyLength  = 32
+)
+
+var phcEncoding = base64.RawStdEncoding
+
+func passwordSalt() []byte {
+	salt := make([]byte, passwordSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		panic(err)
+	}
+	return salt
+}
+
+// phcHash is a hash in the PHC string format,
+// $<id>[$v=<version>]$<param>=<value>,...$<salt>$<hash>, with integer
+// parameters.
+type phcHash struct {
+	id      string
+	version int
+	params  map[string]int
+	salt    []byte
+	hash    []byte
+}
+
+func parsePHC(encoded string) (*phcHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 && len(parts) != 6 || parts[0] != "" {
+		return nil, MalformedPasswordHash
+	}
+	p := &phcHash{id: parts[1], params: make(map[string]int)}
+	rest := parts[2:]
+	if len(parts) == 6 {
+		if !strings.HasPrefix(parts[2], "v=") {
+			return nil, MalformedPasswordHash
+		}
+		v, err := strconv.Atoi(strings.TrimPrefix(parts[2], "v="))
+		if err != nil {
+			return nil, MalformedPasswordHash
+		}
+		p.version, rest = v, parts[3:]
+	}
+	for _, kv := range strings.Split(rest[0], ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 {
+			return nil, MalformedPasswordHash
+		}
+		v, err := strconv.Atoi(pair[1])
+		if err != nil || v < 0 {
+			return nil, MalformedPasswordHash
+		}
+		p.params[pair[0]] = v
+	}
+	var err error
+	if p.salt, err = phcEncoding.DecodeString(rest[1]); err != nil {
+		return nil, MalformedPasswordHash
+	}
+	if p.hash, err = phcEncoding.DecodeString(rest[2]); err != nil || len(p.hash) == 0 {
+		return nil, MalformedPasswordHash
+	}
+	return p, nil
+}
+
+func phcIdentifies(encoded, id string) bool {
+	return strings.HasPrefix(encoded, "$"+id+"$")
+}
+
+func phcEncode(prefix string, salt, hash []byte) string {
+	return fmt.Sprintf("%s$%s$%s", prefix, phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(hash))
+}
+
+// Argon2id hashes passwords with argon2id, memory given in KiB.
+type Argon2id struct {
+	Time    uint32
+	Memory  uint32
+	Threads uint8
+}
+
+// NewArgon2id returns an Argon2id hasher with the minimum parameters OWASP
+// recommends.
+func NewArgon2id() *Argon2id {
+	return &Argon2id{Time: 2, Memory: 19 * 1024, Threads: 1}
+}
+
+func (a *Argon2id) Identifies(encoded string) bool {
+	return phcIdentifies(encoded, "argon2id")
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	salt := passwordSalt()
+	hash := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, passwordKeyLength)
+	prefix := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d", argon2.Version, a.Memory, a.Time, a.Threads)
+	return phcEncode(prefix, salt, hash), nil
+}
+
+func (a *Argon2id) parse(encoded string) (*phcHash, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return nil, err
+	}
+	m, t, threads := p.params["m"], p.params["t"], p.params["p"]
+	if p.id != "argon2id" || p.version != argon2.Version || t < 1 || threads < 1 || threads > 255 || m < 8*threads {
+		return nil, MalformedPasswordHash
+	}
+	return p, nil
+}
+
+func (a *Argon2id) Verify(password, encoded string) (bool, error) {
+	p, err := a.parse(encoded)
+	if err != nil {
+		return false, err
+	}
+	hash := argon2.IDKey([]byte(password), p.salt, uint32(p.params["t"]), uint32(p.params["m"]), uint8(p.params["p"]), uint32(len(p.hash)))
+	return subtle.ConstantTimeCompare(hash, p.hash) == 1, nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	p, err := a.parse(encoded)
+	if err != nil {
+		return true
+	}
+	return p.params["m"] != int(a.Memory) || p.params["t"] != int(a.Time) || p.params["p"] != int(a.Threads) || len(p.hash) != passwordKeyLength
+}
+
+// Bcrypt hashes passwords with bcrypt, which only considers the first 72
+// bytes of a password.
+type Bcrypt struct {
+	Cost int
+}
+
+func NewBcrypt() *Bcrypt {
+	return &Bcrypt{Cost: 12}
+}
+
+func (b *Bcrypt) Identifies(encoded string) bool {
+	return phcIdentifies(encoded, "2a") || phcIdentifies(encoded, "2b") || phcIdentifies(encoded, "2y")
+}
+
+func (b *Bcrypt) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b *Bcrypt) Verify(password, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	}
+	return false, MalformedPasswordHash
+}
+
+func (b *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != b.Cost
+}
+
+// Scrypt hashes passwords with scrypt, with a cost of 2^LogN.
+type Scrypt struct {
+	LogN int
+	R    int
+	P    int
+}
+
+func NewScrypt() *Scrypt {
+	return &Scrypt{LogN: 17, R: 8, P: 1}
+}
+
+func (s *Scrypt) Identifies(encoded string) bool {
+	return phcIdentifies(encoded, "scrypt")
+}
+
+func (s *Scrypt) Hash(password string) (string, error) {
+	salt := passwordSalt()
+	hash, err := scrypt.Key([]byte(password), salt, 1<<uint(s.LogN), s.R, s.P, passwordKeyLength)
+	if err != nil {
+		return "", err
+	}
+	return phcEncode(fmt.Sprintf("$scrypt$ln=%d,r=%d,p=%d", s.LogN, s.R, s.P), salt, hash), nil
+}
+
+func (s *Scrypt) parse(encoded string) (*phcHash, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if ln := p.params["ln"]; p.id != "scrypt" || ln < 1 || ln > 30 || p.params["r"] < 1 || p.params["p"] < 1 {
+		return nil, MalformedPasswordHash
+	}
+	return p, nil
+}
+
+func (s *Scrypt) Verify(password, encoded string) (bool, error) {
+	p, err := s.parse(encoded)
+	if err != nil {
+		return false, err
+	}
+	hash, err := scrypt.Key([]byte(password), p.salt, 1<<uint(p.params["ln"]), p.params["r"], p.params["p"], len(p.hash))
+	if err != nil {
+		return false, MalformedPasswordHash
+	}
+	return subtle.ConstantTimeCompare(hash, p.hash) == 1, nil
+}
+
+func (s *Scrypt) NeedsRehash(encoded string) bool {
+	p, err := s.parse(encoded)
+	if err != nil {
+		return true
+	}
+	return p.params["ln"] != s.LogN || p.params["r"] != s.R || p.params["p"] != s.P || len(p.hash) != passwordKeyLength
+}
+
+// PBKDF2 hashes passwords with PBKDF2-HMAC-SHA256.
+type PBKDF2 struct {
+	Iterations int
+}
+
+func NewPBKDF2() *PBKDF2 {
+	return &PBKDF2{Iterations: 600000}
+}
+
+func (k *PBKDF2) Identifies(encoded string) bool {
+	return phcIdentifies(encoded, "pbkdf2-sha256")
+}
+
+func (k *PBKDF2) Hash(password string) (string, error) {
+	salt := passwordSalt()
+	hash := pbkdf2.Key([]byte(password), salt, k.Iterations, passwordKeyLength, sha256.New)
+	return phcEncode(fmt.Sprintf("$pbkdf2-sha256$i=%d", k.Iterations), salt, hash), nil
+}
+
+func (k *PBKDF2) parse(encoded string) (*phcHash, error) {
+	p, err := parsePHC(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if p.id != "pbkdf2-sha256" || p.params["i"] < 1 {
+		return nil, MalformedPasswordHash
+	}
+	return p, nil
+}
+
+func (k *PBKDF2) Verify(password, encoded string) (bool, error) {
+	p, err := k.parse(encoded)
+	if err != nil {
+		return false, err
+	}
+	hash := pbkdf2.Key([]byte(password), p.salt, p.params["i"], len(p.hash), sha256.New)
+	return subtle.ConstantTimeCompare(hash, p.hash) == 1, nil
+}
+
+func (k *PBKDF2) NeedsRehash(encoded string) bool {
+	p, err := k.parse(encoded)
+	if err != nil {
+		return true
+	}
+	return p.params["i"] != k.Iterations || len(p.hash) != passwordKeyLength
+}
+
+// DefaultPasswordHashers hashes new passwords with argon2id, and verifies
+// passwords hashed with any of argon2id, bcrypt, scrypt or PBKDF2.
+func DefaultPasswordHashers() []PasswordHasher {
+	return []PasswordHasher{NewArgon2id(), NewBcrypt(), NewScrypt(), NewPBKDF2()}
+}
+
+// HashPassword hashes password with the first of the manager's hashers.
+func (s *Manager) HashPassword(password string) (string, error) {
+	return s.hashers[0].Hash(password)
+}
+
+// VerifyPassword reports whether password matches encoded, and whether
+// encoded should be replaced with a hash from HashPassword.
+func (s *Manager) VerifyPassword(password, encoded string) (ok bool, rehash bool, err error) {
+	for i, h := range s.hashers {
+		if h.Identifies(encoded) {
+			ok, err = h.Verify(password, encoded)
+			return ok, ok && (i > 0 || h.NeedsRehash(encoded)), err
+		}
+	}
+	return false, false, UnknownPasswordHash
+}
+
+// setPassword hashes password for users keeping a password hash, leaving
+// other users to Update their own password.
+func (s *Manager) setPassword(usr user.User, password string) error {
+	if _, ok := usr.(user.PasswordHash); !ok {
+		return usr.Update("password", password)
+	}
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	return s.storePassword(usr, password, hash)
+}
+
+// storePassword keeps hash, the encoded hash of password, for users keeping
+// a password hash, leaving other users to Update their own password.
+func (s *Manager) storePassword(usr user.User, password, hash string) error {
+	ph, ok := usr.(user.PasswordHash)
+	if !ok {
+		return usr.Update("password", password)
+	}
+	if err := ph.SetPasswordHash(hash); err != nil {
+		return err
+	}
+	_, err := s.Put(usr)
+	return err
+}
+
+// newUser creates a user in the DataStore, handing it the encoded hash of
+// password and never the password itself, and keeps the hash on users with
+// a PasswordHash.
+func (s *Manager) newUser(email, password string) (user.User, error) {
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	usr, err := s.New(email, hash)
+	if err != nil {
+		return nil, err
+	}
+	if ph, ok := usr.(user.PasswordHash); ok {
+		if err = ph.SetPasswordHash(hash); err == nil {
+			_, err = s.Put(usr)
+		}
+	}
+	return usr, err
+}
+
+// changePassword sets a password chosen by the user, adding it to the
+// password history of users keeping one, which holds the last
+// PASSWORD_HISTORY passwords.
+func (s *Manager) changePassword(usr user.User, password string) error {
+	hash, err := s.HashPassword(password)
+	if err != nil {
+		return err
+	}
+	if err := s.storePassword(usr, password, hash); err != nil {
+		return err
+	}
+	if pc, ok := usr.(user.PasswordChanged); ok {
+		if err := pc.SetPasswordChanged(token.TimeFunc()); err != nil {
+			return err
+		}
+		if _, err := s.Put(usr); err != nil {
+			return err
+		}
+	}
+	ph, ok := usr.(user.PasswordHistory)
+	n := settingInt(s, "password_history")
+	if !ok || n < 1 {
+		return nil
+	}
+	history := append([]string{hash}, ph.PasswordHistory()...)
+	if len(history) > n {
+		history = history[:n]
+	}
+	if err = ph.SetPasswordHistory(history); err == nil {
+		_, err = s.Put(usr)
+	}
+	return err
+}
+
+// changedSince reports whether the password of usr changed after issued, the
+// time a reset link was minted. Issue times are whole seconds, so the change
+// is compared at that precision, and a link minted in the second of the
+// change still stands.
+func changedSince(usr user.User, issued time.Time) bool {
+	pc, ok := usr.(user.PasswordChanged)
+	return ok && issued.Before(pc.PasswordChanged().Truncate(time.Second))
+}
+
+// reusedPassword reports whether password is the current password of usr, or
+// one in its password history.
+func (s *Manager) reusedPassword(usr user.User, password string) bool {
+	if ph, ok := usr.(user.PasswordHash); ok && ph.PasswordHash() != "" {
+		if same, _, _ := s.VerifyPassword(password, ph.PasswordHash()); same {
+			return true
+		}
+	} else if usr.Authenticate(password) == nil {
+		return true
+	}
+	if ph, ok := usr.(user.PasswordHistory); ok {
+		for _, hash := range ph.PasswordHistory() {
+			if same, _, _ := s.VerifyPassword(password, hash); same {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// authenticate checks password for usr. The hash of a user keeping one is
+// verified by the manager and replaced when outdated; a user with no hash yet
+// authenticates itself, and is given one on success. A failure to store the
+// new hash is returned.
+func (s *Manager) authenticate(usr user.User, password string) error {
+	ph, ok := usr.(user.PasswordHash)
+	if !ok || ph.PasswordHash() == "" {
+		if err := usr.Authenticate(password); err != nil {
+			return err
+		}
+		if ok {
+			return s.setPassword(usr, password)
+		}
+		return nil
+	}
+	valid, rehash, err := s.VerifyPassword(password, ph.PasswordHash())
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return MsgError(s, "invalid_password")
+	}
+	if rehash {
+		return s.setPassword(usr, password)
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package security
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thrisp/security/user"
+)
+
+func testHashers() []PasswordHasher {
+	return []PasswordHasher{
+		&Argon2id{Time: 1, Memory: 64, Threads: 1},
+		&Bcrypt{Cost: 4},
+		&Scrypt{LogN: 4, R: 8, P: 1},
+		&PBKDF2{Iterations: 10},
+	}
+}
+
+func TestPasswordHashers(t *testing.T) {
+	prefixes := []string{"$argon2id$v=19$m=64,t=1,p=1$", "$2a$04$", "$scrypt$ln=4,r=8,p=1$", "$pbkdf2-sha256$i=10$"}
+	hashers := testHashers()
+	for i, h := range hashers {
+		encoded, err := h.Hash("correct horse")
+		if err != nil {
+			t.Fatalf("%T: %s", h, err)
+		}
+		if !strings.HasPrefix(encoded, prefixes[i]) {
+			t.Errorf("%T: expected a hash beginning %s, but was %s", h, prefixes[i], encoded)
+		}
+		for j, other := range hashers {
+			if other.Identifies(encoded) != (i == j) {
+				t.Errorf("%T: identification of %s was %v", other, encoded, i != j)
+			}
+		}
+		if ok, err := h.Verify("correct horse", encoded); !ok || err != nil {
+			t.Errorf("%T: expected the password to verify, but was %v %v", h, ok, err)
+		}
+		if ok, _ := h.Verify("battery staple", encoded); ok {
+			t.Errorf("%T: expected another password not to verify", h)
+		}
+		if h.NeedsRehash(encoded) {
+			t.Errorf("%T: expected a hash with current parameters not to need rehashing", h)
+		}
+		if again, _ := h.Hash("correct horse"); again == encoded {
+			t.Errorf("%T: expected hashes to be salted", h)
+		}
+	}
+	if !(&Argon2id{Time: 2, Memory: 64, Threads: 1}).NeedsRehash(mustHash(t, hashers[0])) {
+		t.Errorf("expected a change of argon2id time to need rehashing")
+	}
+	if !(&Bcrypt{Cost: 5}).NeedsRehash(mustHash(t, hashers[1])) {
+		t.Errorf("expected a change of bcrypt cost to need rehashing")
+	}
+	if !(&Scrypt{LogN: 5, R: 8, P: 1}).NeedsRehash(mustHash(t, hashers[2])) {
+		t.Errorf("expected a change of scrypt cost to need rehashing")
+	}
+	if !(&PBKDF2{Iterations: 20}).NeedsRehash(mustHash(t, hashers[3])) {
+		t.Errorf("expected a change of PBKDF2 iterations to need rehashing")
+	}
+}
+
+func mustHash(t *testing.T, h PasswordHasher) string {
+	encoded, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoded
+}
+
+func TestMalformedPasswordHash(t *testing.T) {
+	for _, encoded := range []string{
+		"",
+		"$argon2id$v=19$m=64,t=1,p=1$c2FsdA",
+		"$argon2id$v=18$m=64,t=1,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=0,p=1$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=64,t=1,p=x$c2FsdA$aGFzaA",
+		"$scrypt$ln=99,r=8,p=1$c2FsdA$aGFzaA",
+		"$pbkdf2-sha256$i=10$c2FsdA$",
+		"$pbkdf2-sha256$i=10$!!$aGFzaA",
+	} {
+		for _, h := range testHashers() {
+			if h.Identifies(encoded) {
+				if _, err := h.Verify("password", encoded); err != MalformedPasswordHash {
+					t.Errorf("%T: expected %q to be malformed, but was %v", h, encoded, err)
+				}
+			}
+		}
+	}
+}
+
+func TestAuthenticateRehash(t *testing.T) {
+	hashers := testHashers()
+	s := New(WithUserDataStore(TDataStore()), WithPasswordHashers(hashers[1:]...))
+	usr := s.Get("test-0").(*testUser)
+	if err := s.authenticate(usr, "wrong"); err == nil {
+		t.Errorf("expected a wrong password to fail")
+	}
+	if usr.Hash != "" {
+		t.Errorf("expected no hash to be stored after a failed login")
+	}
+	if err := s.authenticate(usr, "XXXX"); err != nil || !hashers[1].Identifies(usr.Hash) {
+		t.Fatalf("expected a login to store a bcrypt hash, but was %q (%v)", usr.Hash, err)
+	}
+	usr.Password = ""
+	if err := s.authenticate(usr, "wrong"); err == nil {
+		t.Errorf("expected a wrong password to fail against the hash")
+	}
+	s.Configuration(WithPasswordHashers(hashers...))
+	if ok, rehash, _ := s.VerifyPassword("XXXX", usr.Hash); !ok || !rehash {
+		t.Errorf("expected a hash from a secondary hasher to need rehashing")
+	}
+	if err := s.authenticate(usr, "XXXX"); err != nil || !hashers[0].Identifies(usr.Hash) {
+		t.Errorf("expected a login to rehash with argon2id, but was %q (%v)", usr.Hash, err)
+	}
+	if _, _, err := s.VerifyPassword("XXXX", "$md5$abc"); err != UnknownPasswordHash {
+		t.Errorf("expected an unknown hash to be reported, but was %v", err)
+	}
+}
+
+type failingPutStore struct {
+	*testDataStore
+}
+
+func (f failingPutStore) Put(u user.User) (user.User, error) {
+	return u, errors.New("put failed")
+}
+
+func TestAuthenticateRehashError(t *testing.T) {
+	s := New(WithUserDataStore(failingPutStore{TDataStore()}), WithPasswordHashers(testHashers()...))
+	if err := s.authenticate(s.Get("test-0"), "XXXX"); err == nil {
+		t.Errorf("expected a failure to store the new hash to be returned")
+	}
+}
+
+func TestReusedPassword(t *testing.T) {
+	hashers := testHashers()
+	s := New(WithUserDataStore(TDataStore()), WithPasswordHashers(hashers...))
+	usr := s.Get("test-0").(*testUser)
+	if !s.reusedPassword(usr, "XXXX") {
+		t.Errorf("expected the current password of a user without a hash to be reused")
+	}
+	usr.Hash, _ = hashers[0].Hash("saffron-kettle-91")
+	usr.History = []string{usr.Hash, mustHash(t, hashers[3])}
+	if !s.reusedPassword(usr, "saffron-kettle-91") {
+		t.Errorf("expected the current password to be reused")
+	}
+	if !s.reusedPassword(usr, "correct horse") {
+		t.Errorf("expected a password in the history to be reused")
+	}
+	if s.reusedPassword(usr, "walnut-harbor-37") {
+		t.Errorf("expected a new password not to be reused")
+	}
+}
+
+func TestNewUser(t *testing.T) {
+	hashers := testHashers()
+	s := New(WithUserDataStore(TDataStore()), WithPasswordHashers(hashers...))
+	created, err := s.newUser("test-5@test.com", "walnut-harbor-37")
+	if err != nil {
+		t.Fatal(err)
+	}
+	usr := created.(*testUser)
+	if usr.Password == "walnut-harbor-37" || !hashers[0].Identifies(usr.Password) {
+		t.Errorf("expected the data store to be given a password hash, but was %q", usr.Password)
+	}
+	if usr.Hash != usr.Password {
+		t.Errorf("expected the hash given to the data store to be kept, but was %q", usr.Hash)
+	}
+	if err := s.authenticate(usr, "walnut-harbor-37"); err != nil {
+		t.Errorf("expected the new user to log in with its password, but was %v", err)
+	}
+}
+
+func TestPasswordChanged(t *testing.T) {
+	s := New(WithUserDataStore(TDataStore()), WithPasswordHashers(testHashers()...))
+	usr := s.Get("test-0").(*testUser)
+	issued := time.Now().Add(-time.Minute)
+	if changedSince(usr, issued) {
+		t.Errorf("expected a reset link to stand before the password changes")
+	}
+	if err := s.changePassword(usr, "saffron-kettle-91"); err != nil {
+		t.Fatal(err)
+	}
+	if !changedSince(usr, issued) {
+		t.Errorf("expected a reset link issued before a password change to be refused")
+	}
+	if changedSince(usr, time.Now().Add(time.Minute)) {
+		t.Errorf("expected a reset link issued after a password change to stand")
+	}
+	if changedSince(usr, usr.Changed.Truncate(time.Second)) {
+		t.Errorf("expected a reset link issued in the second of a password change to stand")
+	}
+}
+
+func TestChangePasswordHash(t *testing.T) {
+	s := New(WithUserDataStore(TDataStore()), WithPasswordHashers(testHashers()...))
+	usr := s.Get("test-0").(*testUser)
+	if err := s.changePassword(usr, "saffron-kettle-91"); err != nil {
+		t.Fatal(err)
+	}
+	if len(usr.History) != 1 || usr.History[0] != usr.Hash {
+		t.Errorf("expected the password history to record the hash kept, but was %v", usr.History)
+	}
+}
@@ -0,0 +1,333 @@
+package security
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// PasswordViolation is the first rule of a PasswordPolicy a password breaks,
+// as the key of its Messages entry and any values for the message.
+type PasswordViolation struct {
+	Message string
+	Values  []string
+}
+
+func violation(message string, values ...string) *PasswordViolation {
+	return &PasswordViolation{message, values}
+}
+
+// PasswordPolicy is the rules new passwords, given on registration,
+// confirmation, reset or change, must follow.
+type PasswordPolicy struct {
+	MinLength     int
+	MaxLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// DisallowEmail refuses passwords containing the user's email address or
+	// the name part of it.
+	DisallowEmail bool
+	// MinStrength is the least PasswordStrength accepted, 0 to accept any.
+	MinStrength int
+	Breached    BreachedPasswords
+}
+
+// Check returns the first rule password, for the user with email, breaks,
+// or nil.
+func (p *PasswordPolicy) Check(password, email string) (*PasswordViolation, error) {
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return violation("password_invalid_length", strconv.Itoa(p.MinLength)), nil
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return violation("password_too_long", strconv.Itoa(p.MaxLength)), nil
+	}
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+	switch {
+	case p.RequireUpper && !upper:
+		return violation("password_requires_upper"), nil
+	case p.RequireLower && !lower:
+		return violation("password_requires_lower"), nil
+	case p.RequireDigit && !digit:
+		return violation("password_requires_digit"), nil
+	case p.RequireSymbol && !symbol:
+		return violation("password_requires_symbol"), nil
+	}
+	inputs := emailInputs(email)
+	if p.DisallowEmail {
+		lowered := strings.ToLower(password)
+		for _, in := range inputs {
+			if len(in) >= 3 && strings.Contains(lowered, in) {
+				return violation("password_contains_email"), nil
+			}
+		}
+	}
+	if p.MinStrength > 0 && PasswordStrength(password, inputs...) < p.MinStrength {
+		return violation("password_too_weak"), nil
+	}
+	if p.Breached != nil {
+		breached, err := p.Breached.Breached(password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			return violation("password_breached"), nil
+		}
+	}
+	return nil, nil
+}
+
+func emailInputs(email string) []string {
+	email = strings.ToLower(email)
+	if email == "" {
+		return nil
+	}
+	return []string{email, strings.Split(email, "@")[0]}
+}
+
+func settingInt(s *Manager, key string) int {
+	n, _ := strconv.Atoi(s.Setting(key))
+	return n
+}
+
+// passwordPolicy returns the policy given with WithPasswordPolicy, or one
+// made from the PASSWORD_ settings.
+func (s *Manager) passwordPolicy() *PasswordPolicy {
+	if s.policy != nil {
+		return s.policy
+	}
+	require := strings.Split(s.Setting("password_require"), ",")
+	p := &PasswordPolicy{
+		MinLength:     settingInt(s, "password_min_length"),
+		MaxLength:     settingInt(s, "password_max_length"),
+		RequireUpper:  existsIn("upper", require...),
+		RequireLower:  existsIn("lower", require...),
+		RequireDigit:  existsIn("digit", require...),
+		RequireSymbol: existsIn("symbol", require...),
+		DisallowEmail: s.BoolSetting("password_disallow_email"),
+		MinStrength:   settingInt(s, "password_min_strength"),
+	}
+	if dir := s.Setting("password_breached_path"); dir != "" {
+		p.Breached = BreachedPasswordDir(dir)
+	}
+	return p
+}
+
+// checkPasswordPolicy applies the password policy, returning a broken rule
+// as an error with its message.
+func (s *Manager) checkPasswordPolicy(password, email string) error {
+	v, err := s.passwordPolicy().Check(password, email)
+	if err != nil {
+		return err
+	}
+	if v != nil {
+		_, out := s.fmtMessage(append([]string{v.Message}, v.Values...)...)
+		return errors.New(out)
+	}
+	return nil
+}
+
+// BreachedPasswords reports passwords known from data breaches.
+type BreachedPasswords interface {
+	Breached(password string) (bool, error)
+}
+
+// BreachedPasswordDir is a directory of files named for the first five hex
+// digits of password SHA-1 hashes, e.g. 5BAA6.txt, each listing the
+// remaining digits of breached hashes as SUFFIX or SUFFIX:COUNT lines, as
+// the Pwned Passwords range API and downloader give them. Passwords are
+// looked up by prefix only, and never leave the machine.
+type BreachedPasswordDir string
+
+func (d BreachedPasswordDir) Breached(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	digest := strings.ToUpper(hex.EncodeToString(sum[:]))
+	prefix, suffix := digest[:5], digest[5:]
+	f, err := os.Open(filepath.Join(string(d), prefix+".txt"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			if count := strings.TrimSpace(line[i+1:]); count == "0" {
+				continue
+			}
+			line = line[:i]
+		}
+		if strings.EqualFold(line, suffix) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// PasswordStrength scores password from 0, too guessable, to 4, very
+// unguessable, as zxcvbn does from an estimate of the guesses needed to find
+// it. Common passwords, userInputs such as the email address, keyboard rows,
+// sequences and repeats each take few guesses; the remaining characters are
+// guessed by brute force.
+func PasswordStrength(password string, userInputs ...string) int {
+	if utf8.RuneCountInString(password) > 256 {
+		return 4
+	}
+	original := []rune(password)
+	lowered := make([]rune, len(original))
+	for i, r := range original {
+		lowered[i] = unicode.ToLower(r)
+	}
+	guesses := passwordGuesses(lowered, original, userInputs)
+	switch {
+	case guesses < 3:
+		return 0
+	case guesses < 6:
+		return 1
+	case guesses < 8:
+		return 2
+	case guesses < 10:
+		return 3
+	}
+	return 4
+}
+
+// passwordGuesses returns the log10 of the fewest guesses over the ways of
+// splitting password into patterns and brute forced characters.
+func passwordGuesses(lowered, original []rune, userInputs []string) float64 {
+	n := len(lowered)
+	words := make(map[string]float64)
+	for i, w := range commonPasswords {
+		words[w] = math.Log10(float64(i + 2))
+	}
+	for _, w := range userInputs {
+		if w = strings.ToLower(w); utf8.RuneCountInString(w) >= 3 {
+			words[w] = 0
+		}
+	}
+	best := make([]float64, n+1)
+	for i := 1; i <= n; i++ {
+		best[i] = best[i-1] + math.Log10(bruteCardinality(original[i-1]))
+		for j := 0; j <= i-3; j++ {
+			if g, ok := patternGuesses(lowered[j:i], words); ok {
+				// a pattern after others multiplies the guesses by the ways
+				// patterns could be combined, approximated as 10
+				if j > 0 {
+					g++
+				}
+				if g += best[j]; g < best[i] {
+					best[i] = g
+				}
+			}
+		}
+	}
+	return best[n]
+}
+
+func bruteCardinality(r rune) float64 {
+	switch {
+	case unicode.IsDigit(r):
+		return 10
+	case unicode.IsLower(r) || unicode.IsUpper(r):
+		return 26
+	}
+	return 33
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./", "qazwsxedcrfvtgbyhnujmik,ol.p;/"}
+
+// patternGuesses returns the log10 guesses for a segment of three or more
+// lowered characters matching a pattern.
+func patternGuesses(seg []rune, words map[string]float64) (float64, bool) {
+	l := math.Log10(float64(len(seg)))
+	if g, ok := words[string(seg)]; ok {
+		return g, true
+	}
+	if g, ok := words[leetless(seg)]; ok {
+		return g + 1, true
+	}
+	repeat, ascending, descending := true, true, true
+	for i := 1; i < len(seg); i++ {
+		repeat = repeat && seg[i] == seg[0]
+		ascending = ascending && seg[i] == seg[i-1]+1
+		descending = descending && seg[i] == seg[i-1]-1
+	}
+	switch {
+	case repeat:
+		return math.Log10(bruteCardinality(seg[0])) + l, true
+	case ascending || descending:
+		return 1 + l, true
+	}
+	if len(seg) >= 4 {
+		for _, row := range keyboardRows {
+			if strings.Contains(row, string(seg)) || strings.Contains(reverse(row), string(seg)) {
+				return 2 + l, true
+			}
+		}
+	}
+	return 0, false
+}
+
+var leet = map[rune]rune{'4': 'a', '@': 'a', '8': 'b', '3': 'e', '6': 'g', '1': 'i', '!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't', '2': 'z'}
+
+func leetless(seg []rune) string {
+	out := make([]rune, len(seg))
+	for i, r := range seg {
+		if l, ok := leet[r]; ok {
+			r = l
+		}
+		out[i] = r
+	}
+	return string(out)
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+// commonPasswords are among the most used passwords, most used first.
+var commonPasswords = []string{
+	"password", "123456", "123456789", "qwerty", "12345678", "111111",
+	"1234567890", "1234567", "abc123", "password1", "123123", "admin",
+	"letmein", "welcome", "monkey", "login", "dragon", "princess", "football",
+	"baseball", "iloveyou", "master", "sunshine", "ashley", "bailey",
+	"passw0rd", "shadow", "superman", "trustno1", "michael", "jennifer",
+	"hunter", "charlie", "access", "batman", "starwars", "whatever",
+	"freedom", "killer", "secret", "mustang", "hello", "computer", "pepper",
+	"summer", "winter", "spring", "autumn", "flower", "cheese", "soccer",
+	"hockey", "ranger", "buster", "thomas", "robert", "jordan", "hannah",
+	"daniel", "andrew", "jessica", "michelle", "tigger", "purple", "orange",
+	"ginger", "maggie", "cookie", "chocolate", "banana", "chelsea", "liverpool",
+	"arsenal", "matrix", "qwertyuiop", "asdfgh", "zxcvbnm", "changeme", "default",
+	"guest", "root", "test", "user", "pass", "love", "god", "money", "angel",
+	"blink182", "nicole", "lovely", "family", "forever", "friends",
+}
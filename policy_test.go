@@ -0,0 +1,80 @@
+package security
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPasswordPolicy(t *testing.T) {
+	p := &PasswordPolicy{
+		MinLength:     8,
+		MaxLength:     20,
+		RequireUpper:  true,
+		RequireDigit:  true,
+		DisallowEmail: true,
+		MinStrength:   2,
+	}
+	for password, message := range map[string]string{
+		"Sh0rt":                   "password_invalid_length",
+		"Far-too-long-4-this-one": "password_too_long",
+		"lowercase-only-9":        "password_requires_upper",
+		"No-Digits-Here":          "password_requires_digit",
+		"Jdoe-likes-tea-7":        "password_contains_email",
+		"Password123":             "password_too_weak",
+		"Qwerty123456":            "password_too_weak",
+		"Saffron-Kettle-91":       "",
+	} {
+		v, err := p.Check(password, "jdoe@example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if (v == nil && message != "") || (v != nil && v.Message != message) {
+			t.Errorf("expected %q to break %q, but was %+v", password, message, v)
+		}
+	}
+}
+
+func TestPasswordStrength(t *testing.T) {
+	for password, score := range map[string]int{
+		"password":                     0,
+		"p@ssw0rd":                     0,
+		"aaaaaaaaaaaa":                 0,
+		"abcdefgh":                     0,
+		"qwertyuiop":                   0,
+		"jdoe1":                        0,
+		"correct horse battery staple": 4,
+	} {
+		if s := PasswordStrength(password, "jdoe"); s != score {
+			t.Errorf("expected %q to score %d, but was %d", password, score, s)
+		}
+	}
+}
+
+func TestBreachedPasswordDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "breached")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	list := "0018A45C4D1DEF81644B54AB7F969B88D65:1\r\n1E4C9B93F3F0682250B6CF8331B7EE68FD8:9659365\r\n"
+	if err = ioutil.WriteFile(filepath.Join(dir, "5BAA6.txt"), []byte(list), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := BreachedPasswordDir(dir)
+	if breached, err := d.Breached("password"); !breached || err != nil {
+		t.Errorf("expected password to be breached, but was %v %v", breached, err)
+	}
+	if breached, err := d.Breached("saffron-kettle-91"); breached || err != nil {
+		t.Errorf("expected an unlisted password not to be breached, but was %v %v", breached, err)
+	}
+	s := New(WithUserDataStore(TDataStore()), WithPasswordPolicy(&PasswordPolicy{MinLength: 8, Breached: d}))
+	if err := s.checkPasswordPolicy("short", ""); err == nil || err.Error() != "Password must be at least 8 characters" {
+		t.Errorf("expected the length message to give the minimum length, but was %v", err)
+	}
+	if err := s.checkPasswordPolicy("password", ""); err == nil || err.Error() != s.Message("password_breached").String() {
+		t.Errorf("expected a breached password to be refused, but was %v", err)
+	}
+}
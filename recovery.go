@@ -0,0 +1,123 @@
+package security
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/user"
+)
+
+// RecoveryCodeCount is the number of recovery codes generated at a time.
+var RecoveryCodeCount = 10
+
+var recoveryEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateRecoveryCodes returns n random codes formatted for display, e.g.
+// "k3f7q-2mxa9".
+func GenerateRecoveryCodes(n int) []string {
+	codes := make([]string, n)
+	for i := range codes {
+		b := make([]byte, 7)
+		if _, err := rand.Read(b); err != nil {
+			panic(err)
+		}
+		c := strings.ToLower(recoveryEncoding.EncodeToString(b))[:10]
+		codes[i] = fmt.Sprintf("%s-%s", c[:5], c[5:])
+	}
+	return codes
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+}
+
+// HashRecoveryCode returns the hash of code kept in place of the code. The
+// codes are random enough that a fast hash suffices.
+func HashRecoveryCode(code string) string {
+	sum := sha256.Sum256([]byte(normalizeRecoveryCode(code)))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRecoveryCodes replaces the recovery codes of usr with a fresh batch,
+// keeping only their hashes, and returns the codes for display once.
+func (s *Manager) newRecoveryCodes(usr user.User, rc user.RecoveryCodes) ([]string, error) {
+	codes := GenerateRecoveryCodes(RecoveryCodeCount)
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = HashRecoveryCode(c)
+	}
+	if err := rc.SetRecoveryCodes(hashes); err != nil {
+		return nil, err
+	}
+	if _, err := s.Put(usr); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// useRecoveryCode spends code when it is one of the recovery codes of usr,
+// returning the number of codes remaining.
+func (s *Manager) useRecoveryCode(usr user.User, code string) (int, bool) {
+	rc, ok := usr.(user.RecoveryCodes)
+	if !ok || normalizeRecoveryCode(code) == "" {
+		return 0, false
+	}
+	hash := HashRecoveryCode(code)
+	hashes := rc.RecoveryCodes()
+	for i, h := range hashes {
+		if subtle.ConstantTimeCompare([]byte(h), []byte(hash)) != 1 {
+			continue
+		}
+		if fresh, err := s.tokens.Spend("recovery:"+hash, time.Time{}); !fresh || err != nil {
+			return 0, false
+		}
+		remaining := append(append([]string{}, hashes[:i]...), hashes[i+1:]...)
+		if rc.SetRecoveryCodes(remaining) != nil {
+			return 0, false
+		}
+		if _, err := s.Put(usr); err != nil {
+			return 0, false
+		}
+		return len(remaining), true
+	}
+	return 0, false
+}
+
+func getRecoveryCodes(f flotilla.Ctx) {
+	if _, ok := manager(f).CurrentUser().(user.RecoveryCodes); !ok {
+		f.Call("status", 404)
+		return
+	}
+	f.Call("rendertemplate", "recovery_codes.html", nil)
+}
+
+func postRecoveryCodes(f flotilla.Ctx) {
+	posted(
+		f,
+		"recovery_codes",
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			usr := s.CurrentUser()
+			rc, ok := usr.(user.RecoveryCodes)
+			if !ok {
+				f.Call("status", 404)
+				return
+			}
+			codes, err := s.newRecoveryCodes(usr, rc)
+			if err != nil {
+				s.formFail(f, form, "recovery_codes.html")
+				return
+			}
+			shown := s.Forms.byKey("recovery_codes").Fresh()
+			shown.Fields(RecoveryCodeList("recovery-codes").New(codes))
+			f.Call("set", shown.Tag(), shown)
+			s.forwardTo(f, "recovery_codes.html", "recovery_codes_generated")
+		},
+	)
+}
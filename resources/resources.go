@@ -67,6 +67,26 @@ func (fi bindata_file_info) Sys() interface{} {
 	return nil
 }
 
+var _templates_authorize_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xab\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\xaa\xae\x56\x48\x49\x4d\xcb\xcc\x4b\x55\x50\x2a\xca\xcf\x2f\x01\x8b\x29\x00\x01\x50\x5c\xcf\x23\xc4\xd7\x47\x41\x29\xb1\xb4\x24\x23\xbf\x28\xb3\x2a\x35\x3e\x2d\xbf\x28\x17\xa6\x09\x68\x1e\x88\x05\x00\xc9\x88\xf4\xc4\x5d\x00\x00\x00")
+
+func templates_authorize_html_bytes() ([]byte, error) {
+	return bindata_read(
+		_templates_authorize_html,
+		"templates/authorize.html",
+	)
+}
+
+func templates_authorize_html() (*asset, error) {
+	bytes, err := templates_authorize_html_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "templates/authorize.html", size: 93, mode: os.FileMode(436), modTime: time.Unix(1792319701, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _templates_change_password_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xaa\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\x02\xca\xa6\xa4\xa6\x65\xe6\xa5\x2a\x28\x15\xe5\xe7\x97\x80\xc5\x14\x80\x20\xd8\xd5\x39\x34\xc8\x33\x24\x52\xc1\xd9\xc3\xd1\xcf\xdd\x55\x21\xc0\x31\x38\x38\xdc\x3f\xc8\x05\x2c\x09\xd4\xa4\xe7\x11\xe2\xeb\xa3\xa0\x94\x9c\x91\x98\x97\x9e\x1a\x5f\x90\x58\x5c\x5c\x9e\x5f\x94\x12\x9f\x96\x5f\x94\x0b\x33\x17\x68\x25\x88\x05\x08\x00\x00\xff\xff\x55\x0e\x4f\x2d\x80\x00\x00\x00")
 
 func templates_change_password_html_bytes() ([]byte, error) {
@@ -127,6 +147,26 @@ func templates_login_html() (*asset, error) {
 	return a, nil
 }
 
+var _templates_login_code_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xab\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\xaa\xae\x56\x48\x49\x4d\xcb\xcc\x4b\x55\x50\x2a\xca\xcf\x2f\x01\x8b\x29\x00\x01\x50\x5c\xcf\x23\xc4\xd7\x47\x41\x29\x27\x3f\x3d\x33\x2f\x3e\x39\x3f\x25\x35\x3e\x2d\xbf\x28\x17\xa6\x0b\x68\x20\x88\x05\x00\xc9\x67\x70\xe3\x5e\x00\x00\x00")
+
+func templates_login_code_html_bytes() ([]byte, error) {
+	return bindata_read(
+		_templates_login_code_html,
+		"templates/login_code.html",
+	)
+}
+
+func templates_login_code_html() (*asset, error) {
+	bytes, err := templates_login_code_html_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "templates/login_code.html", size: 94, mode: os.FileMode(436), modTime: time.Unix(1792320579, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _templates_passwordless_login_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xaa\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\x02\xca\xa6\xa4\xa6\x65\xe6\xa5\x2a\x28\x15\xe5\xe7\x97\x80\xc5\x14\x80\x20\xd8\xd5\x39\x34\xc8\x33\x24\x52\x21\xc0\x31\x38\x38\xdc\x3f\xc8\xc5\xc7\x35\x38\x38\xde\xc7\xdf\xdd\xd3\x2f\xde\xcd\x3f\xc8\x17\xac\x08\xa8\x59\xcf\x23\xc4\xd7\x47\x41\xa9\x20\xb1\xb8\xb8\x3c\xbf\x28\x25\x27\xb5\xb8\x38\x3e\x27\x3f\x3d\x33\x2f\x3e\x2d\xbf\x28\x17\x66\x05\xd0\x76\x10\x0b\x10\x00\x00\xff\xff\x91\xbc\x22\x6d\x8b\x00\x00\x00")
 
 func templates_passwordless_login_html_bytes() ([]byte, error) {
@@ -147,6 +187,26 @@ func templates_passwordless_login_html() (*asset, error) {
 	return a, nil
 }
 
+var _templates_recovery_codes_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xab\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\xaa\xae\x56\x48\x49\x4d\xcb\xcc\x4b\x55\x50\x2a\xca\xcf\x2f\x01\x8b\x29\x00\x01\x50\x5c\xcf\x23\xc4\xd7\x07\x28\x9c\x9a\x9c\x5f\x96\x5a\x54\x19\x9f\x9c\x9f\x92\x5a\x1c\x9f\x96\x5f\x94\x0b\xd3\x09\x34\x14\xc4\x02\x00\xd0\xf1\xb0\xf6\x62\x00\x00\x00")
+
+func templates_recovery_codes_html_bytes() ([]byte, error) {
+	return bindata_read(
+		_templates_recovery_codes_html,
+		"templates/recovery_codes.html",
+	)
+}
+
+func templates_recovery_codes_html() (*asset, error) {
+	bytes, err := templates_recovery_codes_html_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "templates/recovery_codes.html", size: 98, mode: os.FileMode(436), modTime: time.Unix(1792320168, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 var _templates_register_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xaa\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\x02\xca\xa6\xa4\xa6\x65\xe6\xa5\x2a\x28\x15\xe5\xe7\x97\x80\xc5\x14\x80\x20\xd8\xd5\x39\x34\xc8\x33\x24\x52\x21\xc8\xd5\xdd\x33\x38\xc4\x35\x48\x21\x34\xd8\x35\x08\x2c\x05\xd4\xa2\xe7\x11\xe2\xeb\x03\xd4\x91\x9a\x9e\x59\x5c\x92\x5a\x14\x9f\x96\x5f\x94\x0b\x33\x0e\x68\x13\x88\x05\x08\x00\x00\xff\xff\x3c\xd4\x9f\x3a\x77\x00\x00\x00")
 
 func templates_register_html_bytes() ([]byte, error) {
@@ -247,6 +307,46 @@ func templates_send_reset_html() (*asset, error) {
 	return a, nil
 }
 
+var _templates_two_factor_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xab\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\xaa\xae\x56\x48\x49\x4d\xcb\xcc\x4b\x55\x50\x2a\xca\xcf\x2f\x01\x8b\x29\x00\x01\x50\x5c\xcf\x23\xc4\xd7\x47\x41\xa9\xa4\x3c\x3f\x3e\x2d\x31\xb9\x24\xbf\x28\x3e\x2d\xbf\x28\x17\xa6\x0b\x68\x20\x88\x05\x00\x01\x1d\x43\xff\x5e\x00\x00\x00")
+
+func templates_two_factor_html_bytes() ([]byte, error) {
+	return bindata_read(
+		_templates_two_factor_html,
+		"templates/two_factor.html",
+	)
+}
+
+func templates_two_factor_html() (*asset, error) {
+	bytes, err := templates_two_factor_html_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "templates/two_factor.html", size: 94, mode: os.FileMode(436), modTime: time.Unix(1792320085, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
+var _templates_two_factor_setup_html = []byte("\x1f\x8b\x08\x00\x00\x09\x6e\x88\x00\xff\xab\xae\x56\x48\xad\x28\x49\xcd\x4b\x29\x56\x50\x2a\x4e\x4d\x2e\x2d\xca\x2c\xa9\xd4\xcb\x28\xc9\xcd\x51\x52\xa8\xad\xe5\xaa\xae\x56\x48\x49\x4d\xcb\xcc\x4b\x55\x50\x2a\xca\xcf\x2f\x01\x8b\x29\x00\x01\x50\x5c\xcf\x23\xc4\xd7\x47\x41\xa9\xa4\x3c\x3f\x3e\x2d\x31\xb9\x24\xbf\x28\xbe\x38\xb5\xa4\xb4\x20\x3e\x2d\xbf\x28\x17\xa6\x17\x68\x2c\x88\x05\x00\x80\x1a\x19\x5e\x64\x00\x00\x00")
+
+func templates_two_factor_setup_html_bytes() ([]byte, error) {
+	return bindata_read(
+		_templates_two_factor_setup_html,
+		"templates/two_factor_setup.html",
+	)
+}
+
+func templates_two_factor_setup_html() (*asset, error) {
+	bytes, err := templates_two_factor_setup_html_bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	info := bindata_file_info{name: "templates/two_factor_setup.html", size: 100, mode: os.FileMode(436), modTime: time.Unix(1792320085, 0)}
+	a := &asset{bytes: bytes, info: info}
+	return a, nil
+}
+
 // Asset loads and returns the asset for the given name.
 // It returns an error if the asset could not be found or
 // could not be loaded.
@@ -299,15 +399,20 @@ func AssetNames() []string {
 
 // _bindata is a table, holding each asset generator, mapped to its name.
 var _bindata = map[string]func() (*asset, error){
+	"templates/authorize.html":          templates_authorize_html,
 	"templates/change_password.html":    templates_change_password_html,
 	"templates/confirm_user.html":       templates_confirm_user_html,
 	"templates/login.html":              templates_login_html,
+	"templates/login_code.html":         templates_login_code_html,
 	"templates/passwordless_login.html": templates_passwordless_login_html,
+	"templates/recovery_codes.html":     templates_recovery_codes_html,
 	"templates/register.html":           templates_register_html,
 	"templates/reset_password.html":     templates_reset_password_html,
 	"templates/security.html":           templates_security_html,
 	"templates/send_confirm.html":       templates_send_confirm_html,
 	"templates/send_reset.html":         templates_send_reset_html,
+	"templates/two_factor.html":         templates_two_factor_html,
+	"templates/two_factor_setup.html":   templates_two_factor_setup_html,
 }
 
 // AssetDir returns the file names below a certain
@@ -352,15 +457,20 @@ type _bintree_t struct {
 
 var _bintree = &_bintree_t{nil, map[string]*_bintree_t{
 	"templates": &_bintree_t{nil, map[string]*_bintree_t{
+		"authorize.html":          &_bintree_t{templates_authorize_html, map[string]*_bintree_t{}},
 		"change_password.html":    &_bintree_t{templates_change_password_html, map[string]*_bintree_t{}},
 		"confirm_user.html":       &_bintree_t{templates_confirm_user_html, map[string]*_bintree_t{}},
 		"login.html":              &_bintree_t{templates_login_html, map[string]*_bintree_t{}},
+		"login_code.html":         &_bintree_t{templates_login_code_html, map[string]*_bintree_t{}},
 		"passwordless_login.html": &_bintree_t{templates_passwordless_login_html, map[string]*_bintree_t{}},
+		"recovery_codes.html":     &_bintree_t{templates_recovery_codes_html, map[string]*_bintree_t{}},
 		"register.html":           &_bintree_t{templates_register_html, map[string]*_bintree_t{}},
 		"reset_password.html":     &_bintree_t{templates_reset_password_html, map[string]*_bintree_t{}},
 		"security.html":           &_bintree_t{templates_security_html, map[string]*_bintree_t{}},
 		"send_confirm.html":       &_bintree_t{templates_send_confirm_html, map[string]*_bintree_t{}},
 		"send_reset.html":         &_bintree_t{templates_send_reset_html, map[string]*_bintree_t{}},
+		"two_factor.html":         &_bintree_t{templates_two_factor_html, map[string]*_bintree_t{}},
+		"two_factor_setup.html":   &_bintree_t{templates_two_factor_setup_html, map[string]*_bintree_t{}},
 	}},
 }}
 
@@ -2,6 +2,7 @@ package security
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/thrisp/flotilla"
 
@@ -19,6 +20,14 @@ type Manager struct {
 	login     *login.Manager
 	principal *principal.Manager
 	signed    fork.Field
+	retired   []retiredKey
+	tokens    token.TokenStore
+	clients   OAuthClients
+	providers map[string]*OIDCProvider
+	hashers   []PasswordHasher
+	policy    *PasswordPolicy
+	attempts  AttemptStore
+	sends     SendCounter
 	Settings
 	Urls
 	Times
@@ -30,6 +39,7 @@ type Manager struct {
 
 func (s *Manager) contextualize(c flotilla.Ctx) *Manager {
 	s.login.Reload(c)
+	s.authenticateBearer(c)
 	s.principal.LoadIdentity(c)
 	return s
 }
@@ -59,12 +69,30 @@ func New(c ...Configuration) *Manager {
 
 	s.Forms = defaultForms(s)
 
+	s.principal.Configure(principal.IdentityLoad(s.bearerIdentity))
+
 	err := s.Configuration(c...)
 
 	if s.DataStore == nil {
 		s.DataStore = user.DefaultDataStore()
 	}
 
+	if s.tokens == nil {
+		s.tokens = token.NewMemoryStore()
+	}
+
+	if s.attempts == nil {
+		s.attempts = NewMemoryAttemptStore()
+	}
+
+	if s.sends == nil {
+		s.sends = NewMemorySendCounter()
+	}
+
+	if len(s.hashers) == 0 {
+		s.hashers = DefaultPasswordHashers()
+	}
+
 	err = s.login.Configure(login.UserLoader(s.Get))
 
 	if err != nil {
@@ -140,6 +168,18 @@ func (s *Manager) Signatory(key string) token.Signatory {
 	return s.DefaultSignatory()
 }
 
+// JWKSet is the public verification keys of all signatories, for services
+// verifying tokens minted here. Symmetric signatories contribute nothing.
+func (s *Manager) JWKSet() *token.JWKSet {
+	set := &token.JWKSet{Keys: []*token.JWK{}}
+	for _, sig := range s.Signatories {
+		if ks, ok := sig.(token.KeySet); ok {
+			set.Add(ks.JWKSet().Keys...)
+		}
+	}
+	return set
+}
+
 func (s *Manager) DefaultSignatory() token.Signatory {
 	if sig, ok := s.Signatories["default"]; ok {
 		return sig
@@ -149,6 +189,8 @@ func (s *Manager) DefaultSignatory() token.Signatory {
 
 var securitySignatories []string = []string{
 	"default", "passwordless", "send_confirm", "send_reset", "signed",
+	"access", "refresh", "oauth_code", "oidc_state", "two_factor",
+	"passkey", "login_code", "unlock", "oauth_access",
 }
 
 func (s *Manager) configureSignatories(sigs ...string) {
@@ -160,21 +202,103 @@ func (s *Manager) configureSignatories(sigs ...string) {
 }
 
 func (s *Manager) secret(forSalt string) string {
-	key := s.Setting("secret_key")
+	return s.salted(forSalt, s.Setting("secret_key"))
+}
+
+func (s *Manager) salted(forSalt, key string) string {
 	salt := s.Setting(fmt.Sprintf("%s_salt", forSalt))
 	return fmt.Sprintf("%s%s", salt, key)
 }
 
+// retiredKey is a previous secret and encryption key pair, accepted for
+// verifying and opening tokens until a set time.
+type retiredKey struct {
+	kid, secret, encryption string
+	until                   time.Time
+}
+
+func (s *Manager) newSigner(name, method string) token.Signer {
+	key := s.Setting("secret_key")
+	if token.Symmetric(method) {
+		key = s.secret(name)
+	}
+	kid := s.Setting("signatory_key_id")
+	if kid == "" {
+		return token.NewSigner(method, key)
+	}
+	kr := token.NewKeyring(method, kid, key)
+	for _, r := range s.retired {
+		key := r.secret
+		if token.Symmetric(method) {
+			key = s.salted(name, key)
+		}
+		kr.Retire(r.kid, key, r.until)
+	}
+	return kr
+}
+
 func (s *Manager) newSignatory(name, method string) token.Signatory {
+	opts := []token.SignatoryOption{
+		token.WithValidation(s.validationOptions(name)),
+		token.WithTokenStore(s.tokens),
+	}
+	for _, r := range s.retired {
+		if r.encryption != "" {
+			opts = append(opts, token.WithRetiredKey(r.encryption, r.until))
+		}
+	}
+	if s.BoolSetting("legacy_envelopes") {
+		opts = append(opts, token.WithLegacyEnvelopes())
+	}
+	if alg := s.Setting("jwe_algorithm"); alg != "" {
+		enc, key := s.Setting("jwe_encryption"), s.jweKey(alg)
+		if err := token.CheckJWE(alg, enc, key, s.Setting("signatory_encryption_key")); err != nil {
+			panic(ConfigurationError(err))
+		}
+		opts = append(opts, token.WithJWE(alg, enc, key))
+	}
 	return token.NewSignatory(
 		name,
 		s.Setting("timestamp_format"),
 		s.Setting("signatory_encryption_key"),
-		token.NewSigner(method, s.secret(name)),
+		s.newSigner(name, method),
+		opts...,
 	)
 }
 
-func (s *Manager) Token(from string, claims ...string) string {
+// jweKey is the JWE_KEY for RSA-OAEP, kept apart from the signing keys: the
+// PEM encoded RSA public key of the recipient the tokens are encrypted for,
+// or a private key to also read them back. For other algorithms it is nil,
+// so the signatory encryption key is used.
+func (s *Manager) jweKey(alg string) interface{} {
+	if alg != "RSA-OAEP" {
+		return nil
+	}
+	pem := []byte(s.Setting("jwe_key"))
+	if len(pem) == 0 {
+		panic(ConfigurationError("RSA-OAEP requires a JWE_KEY"))
+	}
+	if key, err := token.ParseRSAPrivateKeyFromPEM(pem); err == nil {
+		return key
+	}
+	key, err := token.ParseRSAPublicKeyFromPEM(pem)
+	if err != nil {
+		panic(ConfigurationError(err))
+	}
+	return key
+}
+
+func (s *Manager) validationOptions(audience string) *token.ValidationOptions {
+	leeway, _ := time.ParseDuration(s.Setting("signatory_leeway"))
+	return &token.ValidationOptions{
+		Issuer:   s.Setting("signatory_issuer"),
+		Audience: audience,
+		Leeway:   leeway,
+		Legacy:   s.BoolSetting("legacy_timestamps"),
+	}
+}
+
+func (s *Manager) Token(from string, claims token.Claims) string {
 	sig := s.Signatory(from)
-	return sig.SignedString(claims...)
+	return sig.SignedWith(claims)
 }
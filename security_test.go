@@ -2,18 +2,23 @@ package security
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"html"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/thrisp/flotilla"
 	"github.com/thrisp/security/principal"
 	"github.com/thrisp/security/token"
 	"github.com/thrisp/security/user"
+	"github.com/thrisp/security/webauthn"
 )
 
 func testApp(m *Manager) *flotilla.App {
@@ -31,6 +36,7 @@ func testManager(settings ...string) *Manager {
 		"after_logout_url:/after/logout",
 		"after_passwordless_url:/after/passwordless/request",
 		"after_passwordless_token_url:/after/passwordless/login",
+		"after_passwordless_code_url:/after/passwordless/code",
 		"after_reset_url:/after/password/reset",
 		"after_change_url:/after/password/change",
 		"after_register_url:/after/register",
@@ -105,7 +111,13 @@ func (td *testDataStore) Delete(u user.User) error {
 type testUser struct {
 	Username  string
 	Password  string
+	Hash      string
+	History   []string
+	Changed   time.Time
 	Local     string
+	Secret    string
+	Recovery  []string
+	Keys      []*webauthn.Credential
 	active    bool
 	confirmed bool
 	principal.Identity
@@ -157,6 +169,66 @@ func (u *testUser) Validate(key string, token string) bool {
 	return false
 }
 
+func (u *testUser) TwoFactorSecret() string {
+	return u.Secret
+}
+
+func (u *testUser) SetTwoFactorSecret(secret string) error {
+	u.Secret = secret
+	return nil
+}
+
+func (u *testUser) RecoveryCodes() []string {
+	return u.Recovery
+}
+
+func (u *testUser) SetRecoveryCodes(hashes []string) error {
+	u.Recovery = hashes
+	return nil
+}
+
+func (u *testUser) PasswordHash() string {
+	return u.Hash
+}
+
+func (u *testUser) SetPasswordHash(hash string) error {
+	u.Hash = hash
+	return nil
+}
+
+func (u *testUser) PasswordHistory() []string {
+	return u.History
+}
+
+func (u *testUser) SetPasswordHistory(history []string) error {
+	u.History = history
+	return nil
+}
+
+func (u *testUser) PasswordChanged() time.Time {
+	return u.Changed
+}
+
+func (u *testUser) SetPasswordChanged(changed time.Time) error {
+	u.Changed = changed
+	return nil
+}
+
+func (u *testUser) Passkeys() []*webauthn.Credential {
+	return u.Keys
+}
+
+func (u *testUser) SavePasskey(c *webauthn.Credential) error {
+	for i, k := range u.Keys {
+		if bytes.Equal(k.ID, c.ID) {
+			u.Keys[i] = c
+			return nil
+		}
+	}
+	u.Keys = append(u.Keys, c)
+	return nil
+}
+
 func (u *testUser) Update(key, value string) error {
 	if key == "Local" {
 		u.Local = value
@@ -396,6 +468,101 @@ func testBuffer(t *testing.T, b *bytes.Buffer, expected string) {
 	}
 }
 
+func loginCodeExpectation(status int, code func() string, tkn *string, post ...func(*testing.T, *httptest.ResponseRecorder)) []flotilla.Expectation {
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/p/code")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			*tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(status, "POST", "/test/p/code")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, fmt.Sprintf("login-code=%s", code()), *tkn)
+		},
+	)
+	exp1.SetPost(post...)
+	return []flotilla.Expectation{exp0, exp1}
+}
+
+func TestPasswordlessCode(t *testing.T) {
+	a := testApp(testManager("passwordless:t", "passwordless_code:t", "passwordless_code_attempts:2"))
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postSendLogin", captureEmailerToBuffers(em, tk))
+	sendCode := func() []flotilla.Expectation {
+		var tkn string
+		exp0, _ := flotilla.NoTanage(200, "GET", "/test/p/login")
+		exp0.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				tkn = extractSignedToken(r.Body.Bytes())
+			},
+		)
+		exp1, _ := flotilla.NoTanage(303, "POST", "/test/p/login")
+		exp1.SetPre(
+			func(t *testing.T, r *http.Request) {
+				em.Reset()
+				tk.Reset()
+				mkTokenPost(r, "user-name=test-1@test.com", tkn)
+			},
+		)
+		exp1.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				testBuffer(t, em, "Please log into your account with the code below")
+				testHead(t, r, "LOCATION", "/test/p/code")
+			},
+		)
+		return []flotilla.Expectation{exp0, exp1}
+	}
+	var tkn string
+	wrong := func() string { return "wrong" }
+	right := func() string { return tk.String() }
+	exps := sendCode()
+	exps = append(exps, loginCodeExpectation(200, wrong, &tkn, func(t *testing.T, r *httptest.ResponseRecorder) {
+		testBody(t, r, `<form class="security-form" action="/test/p/code"`)
+	})...)
+	exps = append(exps, loginCodeExpectation(302, right, &tkn, func(t *testing.T, r *httptest.ResponseRecorder) {
+		testHead(t, r, "LOCATION", "/test/after/passwordless/code")
+	})...)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+
+	exps = sendCode()
+	exps = append(exps, loginCodeExpectation(200, wrong, &tkn)...)
+	exps = append(exps, loginCodeExpectation(303, wrong, &tkn, func(t *testing.T, r *httptest.ResponseRecorder) {
+		testHead(t, r, "LOCATION", "/test/p/login")
+	})...)
+	expired, _ := flotilla.NoTanage(303, "GET", "/test/p/code")
+	exps = append(exps, expired)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+}
+
+func TestPasswordlessCodeRetry(t *testing.T) {
+	a := testApp(testManager("passwordless:t", "passwordless_code:t", "passwordless_code_attempts:3"))
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postSendLogin", captureEmailerToBuffers(em, tk))
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/p/login")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(303, "POST", "/test/p/login")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-1@test.com", tkn)
+		},
+	)
+	exps := []flotilla.Expectation{exp0, exp1}
+	// a wrong code keeps the pending login for the right one
+	exps = append(exps, loginCodeExpectation(200, func() string { return "wrong" }, &tkn, func(t *testing.T, r *httptest.ResponseRecorder) {
+		testBody(t, r, `<form class="security-form" action="/test/p/code"`)
+	})...)
+	exps = append(exps, loginCodeExpectation(302, func() string { return tk.String() }, &tkn, func(t *testing.T, r *httptest.ResponseRecorder) {
+		testHead(t, r, "LOCATION", "/test/after/passwordless/code")
+	})...)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+}
+
 func TestPasswordlessLoginLogout(t *testing.T) {
 	a := testApp(testManager("passwordless:t"))
 	var tkn string
@@ -487,7 +654,7 @@ func TestRecover(t *testing.T) {
 	exp4, _ := flotilla.NoTanage(302, "POST", "/test/reset")
 	exp4.SetPre(
 		func(t *testing.T, r *http.Request) {
-			mkTokenPost(r, "confirmable-one=1111&confirmable-two=1111", tkn)
+			mkTokenPost(r, "confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
 		},
 	)
 	exp4.SetPost(
@@ -499,6 +666,183 @@ func TestRecover(t *testing.T) {
 	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, exp4).Perform()
 }
 
+func TestResetPasswordPolicy(t *testing.T) {
+	m := testManager("passwordless:f", "recoverable:t", "password_disallow_email:t")
+	a := testApp(m)
+	reset := m.Token("send_reset", token.Claims{"ut": "test-0@test.com"})
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/reset/%s", reset))
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	// the anonymous reset form checks the password against the account
+	// the link was sent for
+	exp1, _ := flotilla.NoTanage(200, "POST", "/test/reset")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "confirmable-one=Saffron-test-0-91&confirmable-two=Saffron-test-0-91", tkn)
+		},
+	)
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			if m.Get("test-0").(*testUser).Hash != "" {
+				t.Errorf("expected a password containing the email to be refused on reset")
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1).Perform()
+}
+
+func TestResetAfterChange(t *testing.T) {
+	a := testApp(testManager("passwordless:f", "recoverable:t", "changeable:t"))
+	var tkn string
+	exp1, _ := flotilla.NoTanage(200, "GET", "/test/send/reset")
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postSendReset", captureEmailerToBuffers(em, tk))
+	exp2, _ := flotilla.NoTanage(302, "POST", "/test/send/reset")
+	exp2.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-0@test.com", tkn)
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp1, exp2).Perform()
+	reset := tk.String()
+
+	exps := loginExpectations("test-0@test.com", "XXXX")
+	exp3, _ := flotilla.NoTanage(200, "GET", "/test/change")
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp4, _ := flotilla.NoTanage(302, "POST", "/test/change")
+	exp4.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "current-pass=XXXX&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
+		},
+	)
+	exps = append(exps, exp3, exp4)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+
+	exp5, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/reset/%s", reset))
+	exp5.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testBody(t, r, `<form class="security-form" action="/test/send/reset"`)
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp5).Perform()
+}
+
+func TestUnlock(t *testing.T) {
+	a := testApp(testManager("passwordless:f", "lockout_attempts:2", "throttle_free_attempts:5"))
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postLogin", captureEmailerToBuffers(em, tk))
+	attempt := func(password string, post func(*testing.T, *httptest.ResponseRecorder)) []flotilla.Expectation {
+		var tkn string
+		exp0, _ := flotilla.NoTanage(200, "GET", "/test/login")
+		exp0.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				tkn = extractSignedToken(r.Body.Bytes())
+			},
+		)
+		exp1, _ := flotilla.NoTanage(200, "POST", "/test/login")
+		exp1.SetPre(
+			func(t *testing.T, r *http.Request) {
+				em.Reset()
+				tk.Reset()
+				mkTokenPost(r, fmt.Sprintf("user-name=test-1@test.com&user-pass=%s", password), tkn)
+			},
+		)
+		if post != nil {
+			exp1.SetPost(post)
+		}
+		return []flotilla.Expectation{exp0, exp1}
+	}
+	exps := attempt("wrong", nil)
+	exps = append(exps, attempt("wrong", func(t *testing.T, r *httptest.ResponseRecorder) {
+		testBuffer(t, em, "Your account has been locked after too many failed attempts")
+	})...)
+	// the right password is refused while the account is locked
+	exps = append(exps, attempt("XXXX", nil)...)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+
+	exp0, _ := flotilla.NoTanage(302, "GET", fmt.Sprintf("/test/unlock/%s", tk.String()))
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/login")
+		},
+	)
+	exps = append([]flotilla.Expectation{exp0}, loginExpectations("test-1@test.com", "XXXX")...)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+}
+
+func TestSendThrottle(t *testing.T) {
+	a := testApp(testManager("passwordless:f", "recoverable:t", "throttle_free_attempts:2"))
+	send := func(status int) []flotilla.Expectation {
+		var tkn string
+		exp0, _ := flotilla.NoTanage(200, "GET", "/test/send/reset")
+		exp0.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				tkn = extractSignedToken(r.Body.Bytes())
+			},
+		)
+		exp1, _ := flotilla.NoTanage(status, "POST", "/test/send/reset")
+		exp1.SetPre(
+			func(t *testing.T, r *http.Request) {
+				mkTokenPost(r, "user-name=test-1@test.com", tkn)
+			},
+		)
+		return []flotilla.Expectation{exp0, exp1}
+	}
+	// sends count against the account, not only the client asking
+	exps := append(send(302), send(302)...)
+	exps = append(exps, send(200)...)
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+}
+
+func TestEmailLimit(t *testing.T) {
+	a := testApp(testManager("passwordless:f", "recoverable:t", "email_recipient_limit:1"))
+	var sent int
+	for i := 0; i < 2; i++ {
+		var tkn string
+		exp1, _ := flotilla.NoTanage(200, "GET", "/test/send/reset")
+		exp1.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				tkn = extractSignedToken(r.Body.Bytes())
+			},
+		)
+		exp2, _ := flotilla.NoTanage(302, "POST", "/test/send/reset")
+		exp2.SetPre(
+			func(t *testing.T, r *http.Request) {
+				mkTokenPost(r, "user-name=test-0@test.com", tkn)
+			},
+		)
+		exp2.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				testHead(t, r, "LOCATION", "/")
+			},
+		)
+		flotilla.SessionPerformer(t, a, exp1, exp2).Perform()
+	}
+	addManage(a, "getSendReset", func(c flotilla.Ctx) { sent = manager(c).Emailer.(*testEmailer).sent })
+	exp3, _ := flotilla.NoTanage(200, "GET", "/test/send/reset")
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			if sent != 1 {
+				t.Errorf("expected 1 email sent to a limited recipient, but %d were", sent)
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp3).Perform()
+}
+
 func TestChangePassword(t *testing.T) {
 	a := testApp(testManager("passwordless:f", "changeable:t"))
 	var tkn string
@@ -528,10 +872,22 @@ func TestChangePassword(t *testing.T) {
 			testBody(t, r, `<form class="security-form" action="/test/change"`)
 		},
 	)
+	wrong, _ := flotilla.NoTanage(200, "POST", "/test/change")
+	wrong.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "current-pass=wrong&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
+		},
+	)
+	again, _ := flotilla.NoTanage(200, "GET", "/test/change")
+	again.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
 	exp4, _ := flotilla.NoTanage(302, "POST", "/test/change")
 	exp4.SetPre(
 		func(t *testing.T, r *http.Request) {
-			mkTokenPost(r, "user-name=test-0@test.com&confirmable-one=1111&confirmable-two=1111", tkn)
+			mkTokenPost(r, "current-pass=XXXX&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
 		},
 	)
 	exp4.SetPost(
@@ -539,7 +895,19 @@ func TestChangePassword(t *testing.T) {
 			testHead(t, r, "LOCATION", "/test/after/password/change")
 		},
 	)
-	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, exp4).Perform()
+	exp5, _ := flotilla.NoTanage(200, "GET", "/test/change")
+	exp5.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp6, _ := flotilla.NoTanage(200, "POST", "/test/change")
+	exp6.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "current-pass=saffron-kettle-91&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, wrong, again, exp4, exp5, exp6).Perform()
 }
 
 func TestRegister(t *testing.T) {
@@ -556,7 +924,7 @@ func TestRegister(t *testing.T) {
 	exp2, _ := flotilla.NoTanage(302, "POST", "/test/register")
 	exp2.SetPre(
 		func(t *testing.T, r *http.Request) {
-			mkTokenPost(r, "user-name=test-3@test.com&confirmable-one=3333&confirmable-two=3333", tkn)
+			mkTokenPost(r, "user-name=test-3@test.com&confirmable-one=walnut-harbor-37&confirmable-two=walnut-harbor-37", tkn)
 		},
 	)
 	exp2.SetPost(
@@ -610,7 +978,7 @@ func TestConfirm(t *testing.T) {
 	exp4, _ := flotilla.NoTanage(302, "POST", "/test/confirm")
 	exp4.SetPre(
 		func(t *testing.T, r *http.Request) {
-			mkTokenPost(r, "user-name=test-2@test.com&confirmable-one=1111&confirmable-two=1111", tkn)
+			mkTokenPost(r, "user-name=test-2@test.com&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
 		},
 	)
 	exp4.SetPost(
@@ -621,3 +989,799 @@ func TestConfirm(t *testing.T) {
 	addManage(a, "postConfirmUser", testFlashManage(t, "success", "Thank you. Your account email has been confirmed."))
 	flotilla.SessionPerformer(t, a, exp3, exp4).Perform()
 }
+
+func TestRegisterConfirm(t *testing.T) {
+	a := testApp(testManager("registerable:t", "confirmable:t"))
+	var tkn string
+	exp0 := BaseExpectation()
+	exp1, _ := flotilla.NoTanage(200, "GET", "/test/register")
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postRegister", captureEmailerToBuffers(em, tk))
+	exp2, _ := flotilla.NoTanage(302, "POST", "/test/register")
+	exp2.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-5@test.com&confirmable-one=walnut-harbor-37&confirmable-two=walnut-harbor-37", tkn)
+		},
+	)
+	exp2.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/after/register")
+			testBuffer(t, em, "Please confirm your email through the link below:")
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2).Perform()
+	link := tk.String()
+	exp3, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/confirm/%s", link))
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+			testBody(t, r, `<form class="security-form" action="/test/confirm"`)
+		},
+	)
+	exp4, _ := flotilla.NoTanage(302, "POST", "/test/confirm")
+	exp4.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-5@test.com&confirmable-one=walnut-harbor-37&confirmable-two=walnut-harbor-37", tkn)
+		},
+	)
+	exp4.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/after/confirm/user")
+		},
+	)
+	addManage(a, "postConfirmUser", func(c flotilla.Ctx) {
+		if usr := manager(c).Get("test-5@test.com"); !usr.Confirmed() {
+			t.Errorf("expected test-5@test.com to be confirmed")
+		}
+	})
+	flotilla.SessionPerformer(t, a, exp3, exp4).Perform()
+	exp5, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/confirm/%s", link))
+	exp5.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testBody(t, r, `<form class="security-form" action="/test/send/confirm"`)
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp5).Perform()
+}
+
+func TestConfirmOtherUser(t *testing.T) {
+	a := testApp(testManager("confirmable:t"))
+	var tkn string
+	exp0 := BaseExpectation()
+	exp1, _ := flotilla.NoTanage(200, "GET", "/test/send/confirm")
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	em, tk := new(bytes.Buffer), new(bytes.Buffer)
+	addManage(a, "postSendConfirm", captureEmailerToBuffers(em, tk))
+	exp2, _ := flotilla.NoTanage(302, "POST", "/test/send/confirm")
+	exp2.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-0@test.com", tkn)
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2).Perform()
+	exp3, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/confirm/%s", tk.String()))
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp4, _ := flotilla.NoTanage(200, "POST", "/test/confirm")
+	exp4.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-2@test.com&confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
+		},
+	)
+	addManage(a, "postConfirmUser", func(c flotilla.Ctx) {
+		if manager(c).Get("test-2@test.com").Confirmed() {
+			t.Errorf("expected a confirmation link for test-0@test.com not to confirm test-2@test.com")
+		}
+	})
+	flotilla.SessionPerformer(t, a, exp3, exp4).Perform()
+}
+
+func bearerExpectation(status int, path, tkn, email string) flotilla.Expectation {
+	exp, _ := flotilla.NewExpectation(
+		status, "GET", path,
+		func(t *testing.T) flotilla.Manage {
+			return LoginRequired(func(c flotilla.Ctx) {
+				if usr := manager(c).CurrentUser(); usr.Email() != email {
+					t.Errorf("bearer user expected %s, but was %s", email, usr.Email())
+				}
+				c.Call("serveplain", 200, "ok")
+			})
+		},
+	)
+	exp.SetPre(
+		func(t *testing.T, r *http.Request) {
+			if tkn != "" {
+				r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", tkn))
+			}
+		},
+	)
+	return exp
+}
+
+func TestBearer(t *testing.T) {
+	m := testManager("bearer_signatory:default")
+	a := testApp(m)
+	valid := m.Token("default", token.Claims{"ut": "test-0@test.com"})
+	other := m.Token("passwordless", token.Claims{"ut": "test-0@test.com"})
+	exp0 := bearerExpectation(200, "/api/valid", valid, "test-0@test.com")
+	exp1 := bearerExpectation(401, "/api/other", other, "")
+	exp2 := bearerExpectation(401, "/api/invalid", "not-a-token", "")
+	exp3 := bearerExpectation(303, "/api/none", "", "")
+	flotilla.SimplePerformer(t, a, exp0, exp1, exp2, exp3).Perform()
+}
+
+func tokenAPIExpectation(status int, path string, values func() string, post func(map[string]interface{})) flotilla.Expectation {
+	exp, _ := flotilla.NoTanage(status, "POST", path)
+	exp.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkPost(r, values())
+		},
+	)
+	exp.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			body := make(map[string]interface{})
+			if err := json.Unmarshal(r.Body.Bytes(), &body); err != nil {
+				t.Errorf("token api response was not JSON: %s", r.Body)
+			}
+			if post != nil {
+				post(body)
+			}
+		},
+	)
+	return exp
+}
+
+func TestTokenAPI(t *testing.T) {
+	a := testApp(testManager("token_api:t", "bearer_signatory:access"))
+	var access, first, second string
+	exp0 := tokenAPIExpectation(401, "/test/token",
+		func() string { return "email=test-0@test.com&password=wrong" }, nil)
+	exp1 := tokenAPIExpectation(200, "/test/token",
+		func() string { return "email=test-0@test.com&password=XXXX" },
+		func(b map[string]interface{}) {
+			access, first = b["access_token"].(string), b["refresh_token"].(string)
+			if b["token_type"] != "Bearer" || b["access_token"] == "" {
+				t.Errorf("unexpected token response %v", b)
+			}
+		})
+	exp2 := tokenAPIExpectation(200, "/test/token/refresh",
+		func() string { return fmt.Sprintf("refresh_token=%s", first) },
+		func(b map[string]interface{}) {
+			second = b["refresh_token"].(string)
+			if second == first {
+				t.Errorf("refresh token was not rotated")
+			}
+		})
+	exp3 := tokenAPIExpectation(401, "/test/token/refresh",
+		func() string { return fmt.Sprintf("refresh_token=%s", first) }, nil)
+	exp4 := tokenAPIExpectation(401, "/test/token/refresh",
+		func() string { return fmt.Sprintf("refresh_token=%s", second) }, nil)
+	// reusing a refresh token revokes the access tokens of its login too
+	exp5, _ := flotilla.NewExpectation(
+		401, "GET", "/api/revoked",
+		func(t *testing.T) flotilla.Manage {
+			return LoginRequired(func(c flotilla.Ctx) { c.Call("serveplain", 200, "ok") })
+		},
+	)
+	exp5.SetPre(
+		func(t *testing.T, r *http.Request) {
+			r.Header.Set("Authorization", fmt.Sprintf("Bearer %s", access))
+		},
+	)
+	flotilla.SimplePerformer(t, a, exp0, exp1, exp2, exp3, exp4, exp5).Perform()
+}
+
+func TestOAuth(t *testing.T) {
+	m := testManager("oauth:t")
+	m.Configuration(WithOAuthClients(NewOAuthClients(
+		&OAuthClient{ID: "app", Name: "Test App", RedirectURIs: []string{"https://app.test/cb"}, Scopes: []string{"profile"}},
+		&OAuthClient{ID: "svc", Secret: "svc-secret", Scopes: []string{"reports"}},
+	)))
+	a := testApp(m)
+	verifier := "a-code-verifier-of-sufficient-length-for-pkce"
+	authorize := fmt.Sprintf(
+		"/test/oauth/authorize?response_type=code&client_id=app&redirect_uri=%s&scope=profile&state=xyz&code_challenge=%s&code_challenge_method=S256",
+		url.QueryEscape("https://app.test/cb"), pkceS256(verifier),
+	)
+	var tkn, code string
+	exp0, _ := flotilla.NoTanage(303, "GET", authorize)
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/login")
+		},
+	)
+	exp1, _ := flotilla.NoTanage(200, "GET", "/test/login")
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp2, _ := flotilla.NoTanage(302, "POST", "/test/login")
+	exp2.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-0@test.com&&user-pass=XXXX", tkn)
+		},
+	)
+	exp2.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", authorize)
+		},
+	)
+	exp3, _ := flotilla.NoTanage(200, "GET", authorize)
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+			testBody(t, r, `<form class="security-form" action="/test/oauth/authorize"`)
+			testBody(t, r, `<strong>Test App</strong> is asking to access your account`)
+			testBody(t, r, `<li><code>profile</code></li>`)
+		},
+	)
+	exp4, _ := flotilla.NoTanage(302, "POST", "/test/oauth/authorize")
+	exp4.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "approve=true", tkn)
+		},
+	)
+	exp4.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			loc, _ := url.Parse(r.HeaderMap.Get("Location"))
+			code = loc.Query().Get("code")
+			if loc.Host != "app.test" || loc.Query().Get("state") != "xyz" || code == "" {
+				t.Errorf("unexpected authorization redirect %s", loc)
+			}
+		},
+	)
+	grant := func(verifier string) func() string {
+		return func() string {
+			return fmt.Sprintf(
+				"grant_type=authorization_code&client_id=app&redirect_uri=%s&code=%s&code_verifier=%s",
+				url.QueryEscape("https://app.test/cb"), code, verifier,
+			)
+		}
+	}
+	exp5 := tokenAPIExpectation(401, "/test/oauth/token",
+		func() string { return fmt.Sprintf("grant_type=authorization_code&client_id=unknown&code=%s", code) }, nil)
+	exp6 := tokenAPIExpectation(200, "/test/oauth/token", grant(verifier),
+		func(b map[string]interface{}) {
+			if b["scope"] != "profile" || b["access_token"] == "" || b["refresh_token"] == "" {
+				t.Errorf("unexpected token response %v", b)
+			}
+		})
+	exp7 := tokenAPIExpectation(400, "/test/oauth/token", grant(verifier), nil)
+	exp8 := tokenAPIExpectation(200, "/test/oauth/token",
+		func() string {
+			return "grant_type=client_credentials&client_id=svc&client_secret=svc-secret&scope=reports"
+		}, nil)
+	exp9 := tokenAPIExpectation(400, "/test/oauth/token",
+		func() string { return "grant_type=client_credentials&client_id=app" }, nil)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, exp4, exp5, exp6, exp7, exp8, exp9).Perform()
+}
+
+// loginExpectations log in as email, expecting the login to redirect.
+func loginExpectations(email, password string) []flotilla.Expectation {
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/login")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(302, "POST", "/test/login")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, fmt.Sprintf("user-name=%s&user-pass=%s", email, password), tkn)
+		},
+	)
+	return []flotilla.Expectation{exp0, exp1}
+}
+
+func TestOAuthConsentUser(t *testing.T) {
+	m := testManager("oauth:t")
+	m.Configuration(WithOAuthClients(NewOAuthClients(
+		&OAuthClient{ID: "app", RedirectURIs: []string{"https://app.test/cb"}, Scopes: []string{"profile"}},
+	)))
+	a := testApp(m)
+	authorize := fmt.Sprintf(
+		"/test/oauth/authorize?response_type=code&client_id=app&redirect_uri=%s&scope=profile&state=xyz&code_challenge=%s&code_challenge_method=S256",
+		url.QueryEscape("https://app.test/cb"), pkceS256("attacker-verifier"),
+	)
+	var consent string
+	exp0, _ := flotilla.NoTanage(200, "GET", authorize)
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			consent = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	flotilla.SessionPerformer(t, a, append(loginExpectations("test-1@test.com", "XXXX"), exp0)...).Perform()
+	// the consent of one user cannot be submitted from another's session
+	exp1, _ := flotilla.NoTanage(200, "POST", "/test/oauth/authorize")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "approve=true", consent)
+		},
+	)
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			if loc := r.HeaderMap.Get("Location"); loc != "" {
+				t.Errorf("expected no authorization code for another user's consent, but was redirected to %s", loc)
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, append(loginExpectations("test-0@test.com", "XXXX"), exp1)...).Perform()
+}
+
+func TestOAuthAccessToken(t *testing.T) {
+	m := testManager("oauth:t", "token_api:t", "bearer_signatory:access")
+	a := testApp(m)
+	usr := m.Get("test-0@test.com")
+	api := m.issueTokens(usr, token.NewTokenID(), nil)["access_token"].(string)
+	oauth := m.issueTokens(usr, token.NewTokenID(), token.Claims{"cid": "app", "scope": "profile"})["access_token"].(string)
+	if tkn, err := m.Signatory("oauth_access").Valid(oauth); err != nil {
+		t.Errorf("expected a valid OAuth access token, but was %v", err)
+	} else if cid, scope := OAuthScope(tkn); cid != "app" || len(scope) != 1 || scope[0] != "profile" {
+		t.Errorf("unexpected OAuth access token client %s and scope %v", cid, scope)
+	}
+	// scope limited tokens do not pass for the user on LoginRequired routes
+	exp0 := bearerExpectation(200, "/api/valid", api, "test-0@test.com")
+	exp1 := bearerExpectation(401, "/api/oauth", oauth, "")
+	flotilla.SimplePerformer(t, a, exp0, exp1).Perform()
+}
+
+func TestOAuthPKCE(t *testing.T) {
+	m := testManager("oauth:t")
+	m.Configuration(WithOAuthClients(NewOAuthClients(
+		&OAuthClient{ID: "app", RedirectURIs: []string{"https://app.test/cb"}},
+	)))
+	a := testApp(m)
+	code := m.Token("oauth_code", token.Claims{
+		"ut":   "test-0@test.com",
+		"cid":  "app",
+		"ruri": "https://app.test/cb",
+		"cc":   pkceS256("right-verifier"),
+	})
+	exp := func(status int, verifier string) flotilla.Expectation {
+		return tokenAPIExpectation(status, "/test/oauth/token",
+			func() string {
+				return fmt.Sprintf("grant_type=authorization_code&client_id=app&redirect_uri=https://app.test/cb&code=%s&code_verifier=%s", code, verifier)
+			}, nil)
+	}
+	// a failed exchange spends the code, so the right verifier comes too late
+	flotilla.SimplePerformer(t, a, exp(400, "wrong-verifier"), exp(400, "right-verifier")).Perform()
+}
+
+func testIssuer(nonce *string) *httptest.Server {
+	key, _ := ioutil.ReadFile("token/resources/sample_key")
+	kr := token.NewKeyring("RS256", "issuer-key", string(key))
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"issuer":                 server.URL,
+				"authorization_endpoint": server.URL + "/authorize",
+				"token_endpoint":         server.URL + "/token",
+				"jwks_uri":               server.URL + "/jwks",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(kr.JWKSet())
+		case "/token":
+			if id, secret, _ := r.BasicAuth(); id != "rp" || secret != "rp-secret" || r.PostFormValue("code") != "issued-code" {
+				w.WriteHeader(400)
+				return
+			}
+			tkn := token.New(kr)
+			tkn.Header["kid"] = kr.KeyID()
+			tkn.Claims = token.Claims{
+				"iss":            server.URL,
+				"aud":            "rp",
+				"sub":            "subject-1",
+				"email":          "test-1@test.com",
+				"email_verified": true,
+				"nonce":          *nonce,
+				"iat":            token.NumericDate(time.Now()),
+				"exp":            token.NumericDate(time.Now().Add(time.Minute)),
+			}
+			signed, _ := tkn.SignedString(kr.Key())
+			json.NewEncoder(w).Encode(map[string]string{"id_token": signed})
+		default:
+			w.WriteHeader(404)
+		}
+	}))
+	return server
+}
+
+func TestOIDC(t *testing.T) {
+	var nonce, state string
+	issuer := testIssuer(&nonce)
+	defer issuer.Close()
+	m := testManager("oidc:t")
+	m.Configuration(WithOIDCProviders(&OIDCProvider{
+		Name:         "issuer",
+		Issuer:       issuer.URL,
+		ClientID:     "rp",
+		ClientSecret: "rp-secret",
+		Client:       issuer.Client(),
+	}))
+	a := testApp(m)
+	exp0, _ := flotilla.NoTanage(302, "GET", "/test/oidc/login/issuer")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			loc, _ := url.Parse(r.HeaderMap.Get("Location"))
+			q := loc.Query()
+			nonce, state = q.Get("nonce"), q.Get("state")
+			if loc.Path != "/authorize" || q.Get("client_id") != "rp" || q.Get("code_challenge_method") != "S256" || nonce == "" {
+				t.Errorf("unexpected authorization redirect %s", loc)
+			}
+		},
+	)
+	callback := func(status int, location string) flotilla.Expectation {
+		exp, _ := flotilla.NoTanage(status, "GET", "/test/oidc/callback")
+		exp.SetPre(
+			func(t *testing.T, r *http.Request) {
+				r.URL.RawQuery = url.Values{"state": {state}, "code": {"issued-code"}}.Encode()
+			},
+		)
+		exp.SetPost(
+			func(t *testing.T, r *httptest.ResponseRecorder) {
+				testHead(t, r, "Location", location)
+			},
+		)
+		return exp
+	}
+	exp1 := callback(302, "/test/after/login")
+	exp2, _ := flotilla.NewExpectation(
+		200, "GET", "/test/after/login",
+		func(t *testing.T) flotilla.Manage {
+			return LoginRequired(func(c flotilla.Ctx) {
+				testCurrentUser(t, c, "test-1")
+			})
+		},
+	)
+	exp3 := LogoutExpectation("/test/logout", "/test/after/logout")
+	exp4 := callback(303, "/test/login")
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, exp4).Perform()
+}
+
+func TestOIDCValidate(t *testing.T) {
+	var nonce string
+	issuer := testIssuer(&nonce)
+	defer issuer.Close()
+	p := &OIDCProvider{Issuer: issuer.URL, ClientID: "rp", ClientSecret: "rp-secret", Client: issuer.Client()}
+	if err := p.configure(); err != nil {
+		t.Fatalf("discovery failed: %v", err)
+	}
+	nonce = "expected"
+	idToken, err := p.exchange("issued-code", "", "")
+	if err != nil {
+		t.Fatalf("code exchange failed: %v", err)
+	}
+	if _, err := p.validate(idToken, "expected"); err != nil {
+		t.Errorf("expected a valid ID token, but was %v", err)
+	}
+	if _, err := p.validate(idToken, "other"); err == nil {
+		t.Errorf("expected an ID token with another nonce to be rejected")
+	}
+	p.ClientID = "another-rp"
+	if _, err := p.validate(idToken, "expected"); err == nil {
+		t.Errorf("expected an ID token for another audience to be rejected")
+	}
+	if _, err := p.exchange("stolen-code", "", ""); err == nil {
+		t.Errorf("expected an unknown code to fail the exchange")
+	}
+}
+
+func TestTwoFactor(t *testing.T) {
+	m := testManager("two_factor:t")
+	a := testApp(m)
+	secret := GenerateTOTPSecret()
+	m.Get("test-0").(*testUser).SetTwoFactorSecret(secret)
+	code := func() string {
+		c, _ := TOTPCode(secret, time.Now())
+		return c
+	}
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/login")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(302, "POST", "/test/login")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-0@test.com&&user-pass=XXXX", tkn)
+		},
+	)
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/two-factor")
+		},
+	)
+	exp2 := BaseExpectation()
+	exp3, _ := flotilla.NoTanage(200, "GET", "/test/two-factor")
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+			testBody(t, r, `<form class="security-form" action="/test/two-factor"`)
+		},
+	)
+	exp4, _ := flotilla.NoTanage(200, "POST", "/test/two-factor")
+	exp4.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "two-factor-code=000000", tkn)
+		},
+	)
+	exp5, _ := flotilla.NoTanage(302, "POST", "/test/two-factor")
+	exp5.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, fmt.Sprintf("two-factor-code=%s", code()), tkn)
+		},
+	)
+	exp5.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/after/login")
+		},
+	)
+	exp6, _ := flotilla.NewExpectation(
+		200, "GET", "/test/after/login",
+		func(t *testing.T) flotilla.Manage {
+			return LoginRequired(func(c flotilla.Ctx) {
+				testCurrentUser(t, c, "test-0")
+			})
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3, exp4, exp5, exp6).Perform()
+}
+
+func TestTwoFactorAttempts(t *testing.T) {
+	m := testManager("two_factor:t", "two_factor_attempts:2")
+	a := testApp(m)
+	secret := GenerateTOTPSecret()
+	m.Get("test-0").(*testUser).SetTwoFactorSecret(secret)
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/two-factor")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	attempt := func(status int, code func() string) flotilla.Expectation {
+		exp, _ := flotilla.NoTanage(status, "POST", "/test/two-factor")
+		exp.SetPre(
+			func(t *testing.T, r *http.Request) {
+				mkTokenPost(r, fmt.Sprintf("two-factor-code=%s", code()), tkn)
+			},
+		)
+		return exp
+	}
+	wrong := func() string { return "000000" }
+	right := func() string {
+		c, _ := TOTPCode(secret, time.Now())
+		return c
+	}
+	// the last allowed wrong code ends the pending login, so even the right
+	// code is then refused
+	exps := append(loginExpectations("test-0@test.com", "XXXX"), exp0, attempt(200, wrong), attempt(303, wrong), attempt(303, right))
+	flotilla.SessionPerformer(t, a, exps...).Perform()
+}
+
+func TestTwoFactorTokenAPI(t *testing.T) {
+	m := testManager("token_api:t", "bearer_signatory:access", "two_factor:t")
+	a := testApp(m)
+	secret := GenerateTOTPSecret()
+	m.Get("test-0").(*testUser).SetTwoFactorSecret(secret)
+	exp0 := tokenAPIExpectation(401, "/test/token",
+		func() string { return "email=test-0@test.com&password=XXXX" }, nil)
+	exp1 := tokenAPIExpectation(401, "/test/token",
+		func() string { return "email=test-0@test.com&password=XXXX&code=000000" }, nil)
+	exp2 := tokenAPIExpectation(200, "/test/token",
+		func() string {
+			c, _ := TOTPCode(secret, time.Now())
+			return fmt.Sprintf("email=test-0@test.com&password=XXXX&code=%s", c)
+		},
+		func(b map[string]interface{}) {
+			if b["access_token"] == nil {
+				t.Errorf("expected tokens with a valid two-factor code, but was %v", b)
+			}
+		})
+	flotilla.SimplePerformer(t, a, exp0, exp1, exp2).Perform()
+}
+
+func TestTwoFactorEmailLogin(t *testing.T) {
+	m := testManager("passwordless:t", "recoverable:t", "two_factor:t")
+	a := testApp(m)
+	m.Get("test-0").(*testUser).SetTwoFactorSecret(GenerateTOTPSecret())
+	login := m.Token("passwordless", token.Claims{"ut": "test-0@test.com"})
+	exp0, _ := flotilla.NoTanage(302, "GET", fmt.Sprintf("/test/p/login/%s", login))
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/two-factor")
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0).Perform()
+	reset := m.Token("send_reset", token.Claims{"ut": "test-0@test.com"})
+	var tkn string
+	exp1, _ := flotilla.NoTanage(200, "GET", fmt.Sprintf("/test/reset/%s", reset))
+	exp1.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp2, _ := flotilla.NoTanage(302, "POST", "/test/reset")
+	exp2.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "confirmable-one=saffron-kettle-91&confirmable-two=saffron-kettle-91", tkn)
+		},
+	)
+	exp2.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/two-factor")
+		},
+	)
+	exp3, _ := flotilla.NewExpectation(
+		200, "GET", "/test/after/password/reset",
+		func(t *testing.T) flotilla.Manage {
+			return func(c flotilla.Ctx) {
+				if manager(c).CurrentUser().Authenticated() {
+					t.Errorf("expected a reset for a two-factor user not to log in before a code is given")
+				}
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp1, exp2, exp3).Perform()
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	m := testManager("two_factor:t")
+	a := testApp(m)
+	var tkn, secret string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/login")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(302, "POST", "/test/login")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-1@test.com&&user-pass=XXXX", tkn)
+		},
+	)
+	exp2, _ := flotilla.NoTanage(200, "GET", "/test/two-factor/setup")
+	exp2.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+			body := r.Body.String()
+			start := strings.Index(body, "otpauth://totp/")
+			if start < 0 {
+				t.Fatalf("setup page did not contain an otpauth URI:\n%s", body)
+			}
+			uri, _ := url.Parse(html.UnescapeString(strings.SplitN(body[start:], `"`, 2)[0]))
+			secret = uri.Query().Get("secret")
+		},
+	)
+	// a mistyped code leaves the same secret to confirm
+	wrong, _ := flotilla.NoTanage(200, "POST", "/test/two-factor/setup")
+	wrong.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "two-factor-code=000000", tkn)
+		},
+	)
+	wrong.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testBody(t, r, fmt.Sprintf("secret=%s", secret))
+		},
+	)
+	exp3, _ := flotilla.NoTanage(302, "POST", "/test/two-factor/setup")
+	exp3.SetPre(
+		func(t *testing.T, r *http.Request) {
+			c, _ := TOTPCode(secret, time.Now())
+			mkTokenPost(r, fmt.Sprintf("two-factor-code=%s", c), tkn)
+		},
+	)
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			if m.Get("test-1").(*testUser).Secret != secret {
+				t.Errorf("two-factor secret was not stored after a valid confirmation code")
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, wrong, exp3).Perform()
+}
+
+func TestRecoveryCode(t *testing.T) {
+	m := testManager("two_factor:t")
+	a := testApp(m)
+	usr := m.Get("test-0").(*testUser)
+	usr.SetTwoFactorSecret(GenerateTOTPSecret())
+	codes, _ := m.newRecoveryCodes(usr, usr)
+	var tkn string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/login")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp1, _ := flotilla.NoTanage(302, "POST", "/test/login")
+	exp1.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, "user-name=test-0@test.com&&user-pass=XXXX", tkn)
+		},
+	)
+	exp2, _ := flotilla.NoTanage(200, "GET", "/test/two-factor")
+	exp2.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			tkn = extractSignedToken(r.Body.Bytes())
+		},
+	)
+	exp3, _ := flotilla.NoTanage(302, "POST", "/test/two-factor")
+	exp3.SetPre(
+		func(t *testing.T, r *http.Request) {
+			mkTokenPost(r, fmt.Sprintf("two-factor-code=%s", strings.ToUpper(codes[0])), tkn)
+		},
+	)
+	exp3.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			testHead(t, r, "Location", "/test/after/login")
+			if len(usr.Recovery) != RecoveryCodeCount-1 {
+				t.Errorf("expected the used recovery code to be removed, %d remain", len(usr.Recovery))
+			}
+		},
+	)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2, exp3).Perform()
+}
+
+func passkeyPost(status int, body func() string) flotilla.Expectation {
+	exp, _ := flotilla.NoTanage(status, "POST", "/test/passkey/login")
+	exp.SetPre(
+		func(t *testing.T, r *http.Request) {
+			r.Header.Set("Content-Type", "application/json")
+			r.Body = ioutil.NopCloser(strings.NewReader(body()))
+		},
+	)
+	return exp
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	m := testManager("passkeys:t", "passkey_rp_id:login.example.com")
+	a := testApp(m)
+	m.Get("test-0").(*testUser).Keys = []*webauthn.Credential{{ID: []byte("key-0")}}
+	var challenge string
+	exp0, _ := flotilla.NoTanage(200, "GET", "/test/passkey/login?email=test-0@test.com")
+	exp0.SetPost(
+		func(t *testing.T, r *httptest.ResponseRecorder) {
+			var body struct{ PublicKey webauthn.RequestOptions }
+			if err := json.Unmarshal(r.Body.Bytes(), &body); err != nil {
+				t.Fatalf("passkey login options were not JSON: %s", r.Body)
+			}
+			o := body.PublicKey
+			if o.RPID != "login.example.com" || len(o.AllowCredentials) != 1 || o.AllowCredentials[0].ID != webauthn.Encoding.EncodeToString([]byte("key-0")) {
+				t.Errorf("unexpected passkey login options %+v", o)
+			}
+			challenge = o.Challenge
+		},
+	)
+	assertion := func() string {
+		cd := fmt.Sprintf(`{"type":"webauthn.get","challenge":%q,"origin":"https://login.example.com"}`, challenge)
+		return fmt.Sprintf(
+			`{"id":%q,"response":{"clientDataJSON":%q,"authenticatorData":"","signature":""}}`,
+			webauthn.Encoding.EncodeToString([]byte("key-0")),
+			webauthn.Encoding.EncodeToString([]byte(cd)),
+		)
+	}
+	exp1 := passkeyPost(401, assertion)
+	exp2 := passkeyPost(400, assertion)
+	flotilla.SessionPerformer(t, a, exp0, exp1, exp2).Perform()
+}
@@ -0,0 +1,74 @@
+package security
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+)
+
+// SendCounter counts emails sent by key, a recipient or a client address.
+// Sends are counted in fixed windows, each starting with its first send.
+type SendCounter interface {
+	// Count records a send for key, returning the sends counted in the
+	// current window of the given length.
+	Count(key string, window time.Duration) (int, error)
+}
+
+type sendWindow struct {
+	count int
+	until time.Time
+}
+
+// MemorySendCounter is a SendCounter for a single process.
+type MemorySendCounter struct {
+	mu      sync.Mutex
+	windows map[string]*sendWindow
+}
+
+func NewMemorySendCounter() *MemorySendCounter {
+	return &MemorySendCounter{windows: make(map[string]*sendWindow)}
+}
+
+func (m *MemorySendCounter) Count(key string, window time.Duration) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := token.TimeFunc()
+	for k, w := range m.windows {
+		if !now.Before(w.until) {
+			delete(m.windows, k)
+		}
+	}
+	w, ok := m.windows[key]
+	if !ok {
+		w = &sendWindow{until: now.Add(window)}
+		m.windows[key] = w
+	}
+	w.count++
+	return w.count, nil
+}
+
+// sendLimited counts a send to email from the client of f, and reports
+// whether it goes over EMAIL_RECIPIENT_LIMIT sends to the address within
+// EMAIL_RECIPIENT_DURATION, or EMAIL_CLIENT_LIMIT sends from the client
+// within EMAIL_CLIENT_DURATION. A limit of 0 is no limit.
+func (s *Manager) sendLimited(f flotilla.Ctx, email string) bool {
+	limits := []struct{ key, setting string }{
+		{"recipient:" + strings.ToLower(strings.TrimSpace(email)), "email_recipient"},
+		{"client:" + s.clientAddress(request(f)), "email_client"},
+	}
+	var limited bool
+	for _, l := range limits {
+		limit := settingInt(s, l.setting+"_limit")
+		if limit <= 0 {
+			continue
+		}
+		n, err := s.sends.Count(l.key, s.Duration(l.setting+"_duration"))
+		if err != nil || n > limit {
+			limited = true
+		}
+	}
+	return limited
+}
@@ -0,0 +1,27 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thrisp/security/token"
+)
+
+func TestMemorySendCounter(t *testing.T) {
+	now := time.Now()
+	token.TimeFunc = func() time.Time { return now }
+	defer func() { token.TimeFunc = time.Now }()
+	m := NewMemorySendCounter()
+	for i := 1; i <= 3; i++ {
+		if n, _ := m.Count("recipient:a@example.com", time.Hour); n != i {
+			t.Errorf("expected send %d to be counted, but was %d", i, n)
+		}
+		now = now.Add(20 * time.Minute)
+	}
+	if n, _ := m.Count("recipient:a@example.com", time.Hour); n != 1 {
+		t.Errorf("expected sends to be counted afresh after the window, but were %d", n)
+	}
+	if n, _ := m.Count("client:10.0.0.1", time.Hour); n != 1 {
+		t.Errorf("expected sends to be counted by key, but were %d", n)
+	}
+}
@@ -14,6 +14,8 @@ var defaultSettings Settings = Settings{
 	"LOGIN_URL":                  "/login",
 	"PASSWORDLESS_URL":           "/p/login",
 	"PASSWORDLESS_TOKEN_URL":     "/p/login/:token",
+	"PASSWORDLESS_CODE_URL":      "/p/code",
+	"UNLOCK_URL":                 "/unlock/:token",
 	"LOGOUT_URL":                 "/logout",
 	"REGISTER_URL":               "/register",
 	"SEND_RESET_URL":             "/send/reset",
@@ -23,6 +25,18 @@ var defaultSettings Settings = Settings{
 	"SEND_CONFIRM_URL":           "/send/confirm",
 	"CONFIRM_TOKEN_URL":          "/confirm/:token",
 	"CONFIRM_USER_URL":           "/confirm",
+	"JWKS_URL":                   "/.well-known/jwks.json",
+	"ISSUE_TOKEN_URL":            "/token",
+	"REFRESH_TOKEN_URL":          "/token/refresh",
+	"AUTHORIZE_URL":              "/oauth/authorize",
+	"OAUTH_TOKEN_URL":            "/oauth/token",
+	"OIDC_LOGIN_URL":             "/oidc/login/:provider",
+	"OIDC_CALLBACK_URL":          "/oidc/callback",
+	"TWO_FACTOR_URL":             "/two-factor",
+	"TWO_FACTOR_SETUP_URL":       "/two-factor/setup",
+	"RECOVERY_CODES_URL":         "/two-factor/recovery",
+	"PASSKEY_REGISTER_URL":       "/passkey/register",
+	"PASSKEY_LOGIN_URL":          "/passkey/login",
 	"FORGOT_PASSWORD_TEMPLATE":   "forgot_password.html",
 	"LOGIN_USER_TEMPLATE":        "login_user.html",
 	"REGISTER_USER_TEMPLATE":     "register_user.html",
@@ -34,24 +48,81 @@ var defaultSettings Settings = Settings{
 	"REGISTERABLE":               "f",
 	"RECOVERABLE":                "f",
 	"PASSWORDLESS":               "f",
+	"PASSWORDLESS_CODE":          "f",
+	"PASSWORDLESS_CODE_ATTEMPTS": "5",
 	"CHANGEABLE":                 "f",
+	"CHANGE_REFRESH_REQUIRED":    "f",
+	"JWKS":                       "f",
+	"TOKEN_API":                  "f",
+	"OAUTH":                      "f",
+	"OIDC":                       "f",
+	"TWO_FACTOR":                 "f",
+	"TWO_FACTOR_ISSUER":          "",
+	"TWO_FACTOR_ATTEMPTS":        "5",
+	"PASSKEYS":                   "f",
+	"PASSKEY_RP_ID":              "",
+	"PASSKEY_RP_NAME":            "",
+	"PASSKEY_ORIGINS":            "",
+	"PASSKEY_USER_VERIFICATION":  "f",
+	"PASSWORD_MIN_LENGTH":        "8",
+	"PASSWORD_MAX_LENGTH":        "128",
+	"PASSWORD_REQUIRE":           "",
+	"PASSWORD_DISALLOW_EMAIL":    "t",
+	"PASSWORD_MIN_STRENGTH":      "2",
+	"PASSWORD_BREACHED_PATH":     "",
+	"PASSWORD_HISTORY":           "5",
+	"THROTTLE":                   "t",
+	"THROTTLE_FREE_ATTEMPTS":     "3",
+	"THROTTLE_TRUST_FORWARDED":   "f",
+	"LOCKOUT_ATTEMPTS":           "10",
+	"EMAIL_RECIPIENT_LIMIT":      "5",
+	"EMAIL_CLIENT_LIMIT":         "20",
 	"FORM_MENU":                  "t",
 	"NOTIFY_PASSWORD_CHANGE":     "t",
 	"NOTIFY_PASSWORD_RESET":      "t",
 	"SIGNING_METHOD":             "HS256",
 	"TIMESTAMP_FORMAT":           "Mon Jan _2 15:04:05 MST 2006",
 	"SIGNATORY_ENCRYPTION_KEY":   "1234567890abcdeF",
+	"SIGNATORY_KEY_ID":           "",
+	"BEARER_SIGNATORY":           "",
+	"SIGNATORY_ISSUER":           "",
+	"SIGNATORY_LEEWAY":           "0s",
+	"LEGACY_TIMESTAMPS":          "t",
+	"LEGACY_ENVELOPES":           "f",
+	"JWE_ALGORITHM":              "",
+	"JWE_ENCRYPTION":             "",
+	"JWE_KEY":                    "",
 	"DEFAULT_SALT":               "default-salt",
 	"PASSWORDLESS_SALT":          "login-salt",
 	"SEND_CONFIRM_SALT":          "confirm-salt",
 	"SEND_RESET_SALT":            "reset-salt",
 	"SIGNED_SALT":                "signed-salt",
+	"ACCESS_SALT":                "access-salt",
+	"REFRESH_SALT":               "refresh-salt",
+	"OAUTH_CODE_SALT":            "oauth-code-salt",
+	"OAUTH_ACCESS_SALT":          "oauth-access-salt",
+	"OIDC_STATE_SALT":            "oidc-state-salt",
+	"TWO_FACTOR_SALT":            "two-factor-salt",
+	"PASSKEY_SALT":               "passkey-salt",
+	"LOGIN_CODE_SALT":            "login-code-salt",
+	"UNLOCK_SALT":                "unlock-salt",
 	"LEASED_TOKEN_DURATION":      "5m",
 	"PASSWORDLESS_DURATION":      "12h",
 	"SEND_CONFIRM_DURATION":      "60h",
 	"RESET_DURATION":             "60h",
 	"SEND_RESET_DURATION":        "60h",
 	"CHANGE_DURATION":            "60h",
+	"ACCESS_DURATION":            "15m",
+	"REFRESH_DURATION":           "720h",
+	"OAUTH_CODE_DURATION":        "60s",
+	"OIDC_STATE_DURATION":        "10m",
+	"TWO_FACTOR_DURATION":        "5m",
+	"PASSKEY_DURATION":           "5m",
+	"THROTTLE_DURATION":          "1s",
+	"THROTTLE_MAX_DURATION":      "15m",
+	"LOCKOUT_DURATION":           "30m",
+	"EMAIL_RECIPIENT_DURATION":   "1h",
+	"EMAIL_CLIENT_DURATION":      "1h",
 }
 
 func storekey(key string) string {
@@ -59,8 +130,10 @@ func storekey(key string) string {
 }
 
 func (s *Manager) Setting(key string) string {
-	if item, ok := s.App.Env.Store[storekey(key)]; ok {
-		return item.Value
+	if s.App != nil {
+		if item, ok := s.App.Env.Store[storekey(key)]; ok {
+			return item.Value
+		}
 	}
 	if item, ok := s.Settings[strings.ToUpper(key)]; ok {
 		return item
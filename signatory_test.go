@@ -0,0 +1,140 @@
+package security
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thrisp/security/token"
+)
+
+// signatoryManager is a Manager with its own copy of the settings, with
+// items overriding the defaults, and its signatories configured without an
+// App.
+func signatoryManager(items map[string]string, c ...Configuration) *Manager {
+	s := New(c...)
+	s.Settings = make(Settings)
+	for k, v := range defaultSettings {
+		s.Settings[k] = v
+	}
+	for k, v := range items {
+		s.Settings[strings.ToUpper(k)] = v
+	}
+	s.configureSignatories(securitySignatories...)
+	return s
+}
+
+func TestSignatoryRSAOAEP(t *testing.T) {
+	key, err := ioutil.ReadFile("token/resources/sample_key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := signatoryManager(map[string]string{
+		"jwe_algorithm": "RSA-OAEP",
+		"jwe_key":       string(key),
+	})
+	tkn := s.Token("passwordless", token.Claims{"ut": "test-0"})
+	if !token.IsJWE(tkn) {
+		t.Fatalf("expected an RSA-OAEP JWE, but was %s", tkn)
+	}
+	valid, err := s.Signatory("passwordless").Valid(tkn)
+	if err != nil || valid.Claims["ut"] != "test-0" {
+		t.Errorf("expected the RSA-OAEP JWE to be read back, but was %v", err)
+	}
+
+	// a recipient public key encrypts tokens only its private key opens
+	pub, err := ioutil.ReadFile("token/resources/sample_key.pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = signatoryManager(map[string]string{
+		"jwe_algorithm": "RSA-OAEP",
+		"jwe_key":       string(pub),
+	})
+	tkn = s.Token("send_confirm", token.Claims{"ut": "test-0"})
+	if _, err := token.DecryptJWE(tkn, key); err != nil {
+		t.Errorf("expected the recipient to decrypt the RSA-OAEP JWE, but was %v", err)
+	}
+}
+
+// baselineToken is a passwordless token minted by the baseline signatory, in
+// an AES-CFB envelope, with string times and neither aud nor jti, for the
+// settings in legacySettings.
+const baselineToken = "4sjidJNTXX5vvQHzQLlOQktX2edVAo0Cp2Z6Tydchh4zX0HVCtIapsr31SkLH057HmzNI1jP4UF7M4wGTj7hMHq6894liob80zMPdCHy68aNxbM4w_phD9MESgkSO4lDtvaWV1zz7yJyzOrCDQT9v-3Ok9EMlzwWy3U3j-AIiclTf-HMVcwvTNBrc0JUv5h14A2hiOReDXKVUiqz6d4Y4zj_yCVGtMSxBOVZPJyjUMdGYON-R7-NOuh5tQlzSPK7gSSh5A-Qa2j2R5ag7v9jcP8sn-IcllczUQQA8--R5QOUMXlWjBvoNWuL6Q=="
+
+var legacySettings = map[string]string{
+	"secret_key":        "baseline-secret",
+	"timestamp_format":  "20060102150405",
+	"legacy_timestamps": "t",
+	"legacy_envelopes":  "t",
+}
+
+func TestSignatoryLegacyToken(t *testing.T) {
+	s := signatoryManager(legacySettings)
+	sig := s.Signatory("passwordless")
+	tkn, err := sig.Valid(baselineToken)
+	if err != nil {
+		t.Fatalf("expected a baseline token to be valid while migrating, but was %v", err)
+	}
+	if tkn.Claims["ut"] != "test-0" {
+		t.Errorf("expected ut claim test-0, but was %v", tkn.Claims["ut"])
+	}
+	if _, err := sig.Consume(baselineToken); err != nil {
+		t.Errorf("expected a baseline token to be consumed, but was %v", err)
+	}
+	if _, err := sig.Consume(baselineToken); err == nil {
+		t.Errorf("expected a consumed baseline token to be spent")
+	}
+	if _, err := s.Signatory("send_reset").Valid(baselineToken); err == nil {
+		t.Errorf("expected a baseline token for another signatory to be invalid")
+	}
+
+	off := map[string]string{}
+	for k, v := range legacySettings {
+		off[k] = v
+	}
+	delete(off, "legacy_envelopes")
+	if _, err := signatoryManager(off).Signatory("passwordless").Valid(baselineToken); err == nil {
+		t.Errorf("expected baseline tokens to be refused unless LEGACY_ENVELOPES is set")
+	}
+}
+
+func TestSignatoryKeyRotation(t *testing.T) {
+	defer func() { token.TimeFunc = time.Now }()
+
+	before := signatoryManager(map[string]string{
+		"secret_key":               "first-secret",
+		"signatory_encryption_key": "1234567890abcdeF",
+	})
+	tkn := before.Token("passwordless", token.Claims{"ut": "test-0"})
+
+	until := time.Now().Add(time.Hour)
+	after := signatoryManager(map[string]string{
+		"secret_key":               "second-secret",
+		"signatory_encryption_key": "0987654321abcdeF",
+		"signatory_key_id":         "2015-02",
+	}, WithRetiredKey("2015-01", "first-secret", "1234567890abcdeF", until))
+	if _, err := after.Signatory("passwordless").Valid(tkn); err != nil {
+		t.Errorf("expected a token minted without a kid to survive the first rotation, but was %v", err)
+	}
+
+	token.TimeFunc = func() time.Time { return until.Add(time.Minute) }
+	if _, err := after.Signatory("passwordless").Valid(tkn); err == nil {
+		t.Error("expected a token from retired keys to be rejected once they expire")
+	}
+}
+
+func TestSignatoryJWEConfiguration(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(fmt.Sprint(r), "configuration error") {
+			t.Errorf("expected a configuration error for a mismatched JWE_ENCRYPTION, but was %v", r)
+		}
+	}()
+	signatoryManager(map[string]string{
+		"jwe_algorithm":            "dir",
+		"jwe_encryption":           "A256GCM",
+		"signatory_encryption_key": "1234567890abcdeF",
+	})
+}
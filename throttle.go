@@ -0,0 +1,316 @@
+package security
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/token"
+)
+
+// AttemptStore counts failed attempts by key, an account or a client
+// address. Failures need only be kept until the given time, and are counted
+// afresh after.
+type AttemptStore interface {
+	// Fail records a failure for key, returning the failures counted.
+	Fail(key string, until time.Time) (int, error)
+	// Failures returns the failures counted for key, and when the last was.
+	Failures(key string) (int, time.Time, error)
+	// Reset forgets the failures of key.
+	Reset(key string) error
+}
+
+type attempts struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+// MemoryAttemptStore is an AttemptStore for a single process.
+type MemoryAttemptStore struct {
+	mu     sync.Mutex
+	failed map[string]*attempts
+}
+
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{failed: make(map[string]*attempts)}
+}
+
+func (m *MemoryAttemptStore) prune(now time.Time) {
+	for k, a := range m.failed {
+		if now.After(a.until) {
+			delete(m.failed, k)
+		}
+	}
+}
+
+func (m *MemoryAttemptStore) Fail(key string, until time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := token.TimeFunc()
+	m.prune(now)
+	a, ok := m.failed[key]
+	if !ok {
+		a = &attempts{}
+		m.failed[key] = a
+	}
+	a.count++
+	a.last, a.until = now, until
+	return a.count, nil
+}
+
+func (m *MemoryAttemptStore) Failures(key string) (int, time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prune(token.TimeFunc())
+	if a, ok := m.failed[key]; ok {
+		return a.count, a.last, nil
+	}
+	return 0, time.Time{}, nil
+}
+
+func (m *MemoryAttemptStore) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.failed, key)
+	return nil
+}
+
+const (
+	accountAttempts = "account:"
+	sendAttempts    = "send:"
+)
+
+// clientAddress is the address of the client of r, taken from the last
+// X-Forwarded-For entry when THROTTLE_TRUST_FORWARDED says a proxy adds it.
+func (s *Manager) clientAddress(r *http.Request) string {
+	if s.BoolSetting("throttle_trust_forwarded") {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			return strings.TrimSpace(hops[len(hops)-1])
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// attemptKeys are the keys attempts from r are counted under: the client
+// address, and the account when email is given.
+func (s *Manager) attemptKeys(r *http.Request, email string) []string {
+	keys := []string{"client:" + s.clientAddress(r)}
+	if email = strings.ToLower(strings.TrimSpace(email)); email != "" {
+		keys = append([]string{accountAttempts + email}, keys...)
+	}
+	return keys
+}
+
+// throttled reports whether the account of keys is locked out, and how long
+// to wait before another attempt. After THROTTLE_FREE_ATTEMPTS failures each
+// further failure doubles the wait from THROTTLE_DURATION, up to
+// THROTTLE_MAX_DURATION; accounts are locked for LOCKOUT_DURATION after
+// LOCKOUT_ATTEMPTS failures.
+func (s *Manager) throttled(keys []string) (bool, time.Duration) {
+	now := token.TimeFunc()
+	free, lockout := settingInt(s, "throttle_free_attempts"), settingInt(s, "lockout_attempts")
+	base, max := s.Duration("throttle_duration"), s.Duration("throttle_max_duration")
+	var locked bool
+	var wait time.Duration
+	for _, k := range keys {
+		n, last, err := s.attempts.Failures(k)
+		if err != nil || n == 0 {
+			continue
+		}
+		if strings.HasPrefix(k, accountAttempts) && lockout > 0 && n >= lockout {
+			if w := last.Add(s.Duration("lockout_duration")).Sub(now); w > 0 {
+				locked = true
+				if w > wait {
+					wait = w
+				}
+			}
+			continue
+		}
+		if w := last.Add(backoff(n, free, base, max)).Sub(now); w > wait {
+			wait = w
+		}
+	}
+	return locked, wait
+}
+
+// backoff is the wait after n failures: none for the first free, then base
+// doubling with each failure, up to max.
+func backoff(n, free int, base, max time.Duration) time.Duration {
+	if n < free {
+		return 0
+	}
+	if shift := uint(n - free); shift < 32 && base<<shift > 0 && base<<shift < max {
+		return base << shift
+	}
+	return max
+}
+
+// refusal returns the message refusing an attempt while throttled, or nil
+// when the attempt may go ahead.
+func (s *Manager) refusal(keys []string) []string {
+	locked, wait := s.throttled(keys)
+	if wait <= 0 {
+		return nil
+	}
+	if wait < time.Second {
+		wait = time.Second
+	}
+	message := "too_many_attempts"
+	if locked {
+		message = "account_locked"
+	}
+	return []string{message, wait.Round(time.Second).String()}
+}
+
+// failAttempt counts a failure for each of keys, and sends an unlock link to
+// an account once it is locked out.
+func (s *Manager) failAttempt(f flotilla.Ctx, keys []string) {
+	until := s.Expires("lockout_duration")
+	for _, k := range keys {
+		n, err := s.attempts.Fail(k, until)
+		if err == nil && strings.HasPrefix(k, accountAttempts) && n == settingInt(s, "lockout_attempts") {
+			s.sendUnlock(f, strings.TrimPrefix(k, accountAttempts))
+		}
+	}
+}
+
+func spentAttempt(key string, n int) string {
+	return fmt.Sprintf("%s:%d", key, n)
+}
+
+// spendAttempt counts an attempt at a one-off secret, such as an emailed or
+// two-factor code, in the TokenStore, so that starting over with a replayed
+// cookie does not reset the count. It reports whether attempts remain.
+func (s *Manager) spendAttempt(key string, max int, until time.Time) bool {
+	for n := 1; n <= max; n++ {
+		fresh, err := s.tokens.Spend(spentAttempt(key, n), until)
+		if err != nil {
+			return false
+		}
+		if fresh {
+			return n < max
+		}
+	}
+	return false
+}
+
+// attemptsSpent reports whether all max attempts at key were spent.
+func (s *Manager) attemptsSpent(key string, max int) bool {
+	spent, err := s.tokens.Spent(spentAttempt(key, max))
+	return spent || err != nil
+}
+
+func (s *Manager) resetAccount(email string) {
+	s.attempts.Reset(accountAttempts + strings.ToLower(strings.TrimSpace(email)))
+}
+
+func (s *Manager) sendUnlock(f flotilla.Ctx, email string) error {
+	usr := s.Get(email)
+	if usr.Anonymous() || s.sendLimited(f, usr.Email()) {
+		return nil
+	}
+	unlock := s.Token("unlock", token.Claims{
+		"ut":  usr.Token("unlock"),
+		"exp": token.NumericDate(s.Expires("lockout_duration")),
+	})
+	return s.SendMail("unlock", usr.Email(), s.External(f, "getUnlock", unlock))
+}
+
+// throttledPosted is posted for forms open to guessing, refusing attempts
+// while the client, or the account given in field, is throttled, and
+// counting attempts failing the form checks. A valid attempt clears the
+// failures of the account.
+func throttledPosted(f flotilla.Ctx, key, field string, ifvalid IfValid) {
+	s, r := manager(f), request(f)
+	if !s.BoolSetting("throttle") {
+		posted(f, key, ifvalid)
+		return
+	}
+	var email string
+	if field != "" {
+		email = r.PostFormValue(field)
+	}
+	keys := s.attemptKeys(r, email)
+	if refusal := s.refusal(keys); refusal != nil {
+		s.forwardTo(f, fmt.Sprintf("%s.html", key), refusal...)
+		return
+	}
+	var valid bool
+	posted(
+		f,
+		key,
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			valid = true
+			if email != "" {
+				s.resetAccount(email)
+			}
+			ifvalid(f, s, form)
+		},
+	)
+	if !valid {
+		s.failAttempt(f, keys)
+	}
+}
+
+// throttledSend is throttledPosted for forms emailing the account given in
+// field, which prove nothing about it: every send counts against the
+// account, slowing floods of one account from many clients, but never
+// toward locking it or clearing its failures.
+func throttledSend(f flotilla.Ctx, key, field string, ifvalid IfValid) {
+	s, r := manager(f), request(f)
+	if !s.BoolSetting("throttle") {
+		posted(f, key, ifvalid)
+		return
+	}
+	keys := s.attemptKeys(r, "")
+	if email := strings.ToLower(strings.TrimSpace(r.PostFormValue(field))); email != "" {
+		keys = append(keys, sendAttempts+email)
+	}
+	if refusal := s.refusal(keys); refusal != nil {
+		s.forwardTo(f, fmt.Sprintf("%s.html", key), refusal...)
+		return
+	}
+	var valid bool
+	posted(
+		f,
+		key,
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			valid = true
+			ifvalid(f, s, form)
+		},
+	)
+	if !valid {
+		s.failAttempt(f, keys)
+		return
+	}
+	until := s.Expires("throttle_max_duration")
+	for _, k := range keys[1:] {
+		s.attempts.Fail(k, until)
+	}
+}
+
+func getUnlock(f flotilla.Ctx) {
+	s, t := manager(f), tokenFromUrl(f, "token")
+	tkn, err := s.Signatory("unlock").Consume(t)
+	if err != nil {
+		s.forwardTo(f, "login.html", "invalid_unlock_token")
+		return
+	}
+	usr, _ := validUserToken(s, tkn)
+	if usr == nil {
+		s.forwardTo(f, "login.html", "invalid_unlock_token")
+		return
+	}
+	s.resetAccount(usr.Email())
+	s.Flash(f, "account_unlocked")
+	f.Call("redirect", 302, s.BlueprintUrl(s.ManagerLogin()))
+}
@@ -0,0 +1,48 @@
+package security
+
+import (
+	"testing"
+	"time"
+
+	"github.com/thrisp/security/token"
+)
+
+func TestBackoff(t *testing.T) {
+	for n, expect := range map[int]time.Duration{
+		0:  0,
+		2:  0,
+		3:  time.Second,
+		4:  2 * time.Second,
+		8:  32 * time.Second,
+		13: 15 * time.Minute,
+		99: 15 * time.Minute,
+	} {
+		if d := backoff(n, 3, time.Second, 15*time.Minute); d != expect {
+			t.Errorf("expected a wait of %s after %d failures, but was %s", expect, n, d)
+		}
+	}
+}
+
+func TestMemoryAttemptStore(t *testing.T) {
+	now := time.Now()
+	token.TimeFunc = func() time.Time { return now }
+	defer func() { token.TimeFunc = time.Now }()
+	m := NewMemoryAttemptStore()
+	for i := 1; i <= 3; i++ {
+		if n, _ := m.Fail("account:a@example.com", now.Add(time.Minute)); n != i {
+			t.Errorf("expected failure %d to be counted, but was %d", i, n)
+		}
+	}
+	m.Fail("client:10.0.0.1", now.Add(time.Minute))
+	if n, last, _ := m.Failures("account:a@example.com"); n != 3 || !last.Equal(now) {
+		t.Errorf("expected 3 failures, the last now, but was %d at %s", n, last)
+	}
+	m.Reset("account:a@example.com")
+	if n, _, _ := m.Failures("account:a@example.com"); n != 0 {
+		t.Errorf("expected reset failures to be forgotten, but were %d", n)
+	}
+	now = now.Add(2 * time.Minute)
+	if n, _, _ := m.Failures("client:10.0.0.1"); n != 0 {
+		t.Errorf("expected expired failures to be forgotten, but were %d", n)
+	}
+}
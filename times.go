@@ -1,8 +1,11 @@
 package security
 
 import (
+	"strconv"
 	"strings"
 	"time"
+
+	"github.com/thrisp/security/token"
 )
 
 type Times interface {
@@ -25,7 +28,6 @@ func NewTimes(s *Manager) Times {
 	t := &times{
 		timefunc: time.Now,
 		values:   make(map[string]time.Duration),
-		format:   s.Setting("TIMESTAMP_FORMAT"),
 	}
 	for k, _ := range s.Settings {
 		spl := strings.Split(k, "_")
@@ -38,7 +40,6 @@ func NewTimes(s *Manager) Times {
 
 type times struct {
 	timefunc func() time.Time
-	format   string
 	values   map[string]time.Duration
 }
 
@@ -53,6 +54,7 @@ func (t *times) Expires(key string) time.Time {
 	return t.timefunc().Add(t.Duration(key))
 }
 
+// Expiration returns the expiry for key as a NumericDate string.
 func (t *times) Expiration(key string) string {
-	return t.Expires(key).Format(t.format)
+	return strconv.FormatInt(token.NumericDate(t.Expires(key)), 10)
 }
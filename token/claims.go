@@ -0,0 +1,35 @@
+package token
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Claims are typed token claims. Values may be anything encoding/json can
+// marshal: strings, numbers, bools, arrays and nested objects.
+type Claims map[string]interface{}
+
+// StringClaims adapts "key:value" strings to Claims. Each item is split on
+// its first colon only, so values may themselves contain colons. The exp,
+// iat and nbf claims are kept numeric.
+func StringClaims(items ...string) Claims {
+	c := make(Claims)
+	for _, v := range items {
+		sp := strings.SplitN(v, ":", 2)
+		if len(sp) == 2 {
+			c[sp[0]] = claimValue(sp[0], sp[1])
+		}
+	}
+	return c
+}
+
+// claimValue keeps the NumericDate claims numeric.
+func claimValue(key, value string) interface{} {
+	switch key {
+	case "exp", "iat", "nbf":
+		if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return n
+		}
+	}
+	return value
+}
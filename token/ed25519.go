@@ -0,0 +1,139 @@
+package token
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+)
+
+// SigningMethodEd25519 implements the EdDSA signing method over Ed25519.
+type SigningMethodEd25519 struct{}
+
+func (m *SigningMethodEd25519) Verify(signingString, signature string, key interface{}) error {
+	var err error
+
+	// Decode the signature
+	var sig []byte
+	if sig, err = DecodeSegment(signature); err != nil {
+		return err
+	}
+
+	var edKey ed25519.PublicKey
+
+	switch k := key.(type) {
+	case []byte:
+		if edKey, err = ParseEdPublicKeyFromPEM(k); err != nil {
+			return err
+		}
+	case ed25519.PublicKey:
+		edKey = k
+	default:
+		return ErrInvalidKey
+	}
+
+	if len(edKey) != ed25519.PublicKeySize {
+		return ErrInvalidKey
+	}
+
+	// Verify the signature
+	if !ed25519.Verify(edKey, []byte(signingString), sig) {
+		return ErrEd25519Verification
+	}
+	return nil
+}
+
+func (m *SigningMethodEd25519) Sign(signingString string, key interface{}) (string, error) {
+	var err error
+	var edKey ed25519.PrivateKey
+
+	switch k := key.(type) {
+	case []byte:
+		if edKey, err = ParseEdPrivateKeyFromPEM(k); err != nil {
+			return "", err
+		}
+	case ed25519.PrivateKey:
+		edKey = k
+	default:
+		return "", ErrInvalidKey
+	}
+
+	if len(edKey) != ed25519.PrivateKeySize {
+		return "", ErrInvalidKey
+	}
+
+	return EncodeSegment(ed25519.Sign(edKey, []byte(signingString))), nil
+}
+
+func (m *SigningMethodEd25519) Alg() string {
+	return "EdDSA"
+}
+
+var (
+	ErrEd25519Verification = errors.New("ed25519: verification error")
+	ErrNotEdPublicKey      = errors.New("Key is not a valid Ed25519 public key")
+	ErrNotEdPrivateKey     = errors.New("Key is not a valid Ed25519 private key")
+)
+
+// Parse PEM encoded PKCS8 Ed25519 private key
+func ParseEdPrivateKeyFromPEM(key []byte) (ed25519.PrivateKey, error) {
+	var err error
+
+	// Parse PEM block
+	var block *pem.Block
+	if block, _ = pem.Decode(key); block == nil {
+		return nil, ErrKeyMustBePEMEncoded
+	}
+
+	var parsedKey interface{}
+	if parsedKey, err = x509.ParsePKCS8PrivateKey(block.Bytes); err != nil {
+		return nil, err
+	}
+
+	var pkey ed25519.PrivateKey
+	var ok bool
+	if pkey, ok = parsedKey.(ed25519.PrivateKey); !ok {
+		return nil, ErrNotEdPrivateKey
+	}
+
+	return pkey, nil
+}
+
+// Parse PEM encoded PKIX Ed25519 public key or certificate
+func ParseEdPublicKeyFromPEM(key []byte) (ed25519.PublicKey, error) {
+	var err error
+
+	// Parse PEM block
+	var block *pem.Block
+	if block, _ = pem.Decode(key); block == nil {
+		return nil, ErrKeyMustBePEMEncoded
+	}
+
+	// Parse the key
+	var parsedKey interface{}
+	if parsedKey, err = x509.ParsePKIXPublicKey(block.Bytes); err != nil {
+		if cert, err := x509.ParseCertificate(block.Bytes); err == nil {
+			parsedKey = cert.PublicKey
+		} else {
+			return nil, err
+		}
+	}
+
+	var pkey ed25519.PublicKey
+	var ok bool
+	if pkey, ok = parsedKey.(ed25519.PublicKey); !ok {
+		return nil, ErrNotEdPublicKey
+	}
+
+	return pkey, nil
+}
+
+var SigningMethodEdDSA *SigningMethodEd25519
+
+func init() {
+	// EdDSA
+	SigningMethodEdDSA = &SigningMethodEd25519{}
+	RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
@@ -8,6 +8,8 @@ var (
 	ErrHashUnavailable  = errors.New("the requested hash function is unavailable")
 	ErrNoTokenInRequest = errors.New("no token present in request")
 	ErrTokenLength      = errors.New("token length is wrong")
+	ErrInvalidTime      = errors.New("time claim is not a NumericDate")
+	ErrEnvelopeVersion  = errors.New("token envelope version is not supported")
 )
 
 // The errors that might occur when parsing and validating a token
@@ -18,6 +20,13 @@ const (
 	ValidationErrorInvalidTime                         // Parsing time failed
 	ValidationErrorExpired                             // Exp validation failed
 	ValidationErrorNotValidYet                         // NBF validation failed
+	ValidationErrorIssuer                              // ISS validation failed
+	ValidationErrorAudience                            // AUD validation failed
+	ValidationErrorSubject                             // SUB validation failed
+	ValidationErrorClaimRequired                       // A required claim is missing
+	ValidationErrorDecryption                          // Token envelope could not be decrypted
+	ValidationErrorAlgorithm                           // Signing method (alg) is not allowed
+	ValidationErrorSpent                               // Token was already used or revoked
 )
 
 // The error from Parse if token is not valid
@@ -1,6 +1,7 @@
 package token
 
 import (
+	"bytes"
 	"crypto"
 	"crypto/hmac"
 	"errors"
@@ -11,8 +12,18 @@ type SigningMethodHMAC struct {
 	Hash crypto.Hash
 }
 
+// hmacKey returns key as an HMAC secret, refusing PEM encoded key material,
+// so a token naming an HMAC alg is never checked against a public key.
+func hmacKey(key interface{}) ([]byte, bool) {
+	k, ok := key.([]byte)
+	if !ok || bytes.Contains(k, []byte("-----BEGIN")) {
+		return nil, false
+	}
+	return k, true
+}
+
 func (m *SigningMethodHMAC) Verify(signingString, signature string, key interface{}) error {
-	if k, ok := key.([]byte); ok {
+	if k, ok := hmacKey(key); ok {
 		var sig []byte
 		var err error
 		if sig, err = DecodeSegment(signature); err == nil {
@@ -34,7 +45,7 @@ func (m *SigningMethodHMAC) Verify(signingString, signature string, key interfac
 }
 
 func (m *SigningMethodHMAC) Sign(signingString string, key interface{}) (string, error) {
-	if k, ok := key.([]byte); ok {
+	if k, ok := hmacKey(key); ok {
 		if !m.Hash.Available() {
 			return "", ErrHashUnavailable
 		}
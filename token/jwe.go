@@ -0,0 +1,478 @@
+package token
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	keyAlgorithms      = map[string]func() KeyAlgorithm{}
+	contentEncryptions = map[string]func() ContentEncryption{}
+)
+
+// KeyAlgorithm is a JWE key management algorithm, producing and recovering
+// the content encryption key of a token.
+type KeyAlgorithm interface {
+	Alg() string
+	// EncryptKey returns a content encryption key of size bytes and its
+	// encrypted form for the token.
+	EncryptKey(key interface{}, size int) (cek, encryptedKey []byte, err error)
+	// DecryptKey recovers a content encryption key of size bytes.
+	DecryptKey(encryptedKey []byte, key interface{}, size int) ([]byte, error)
+}
+
+// ContentEncryption is a JWE content encryption algorithm.
+type ContentEncryption interface {
+	Enc() string
+	KeySize() int
+	Encrypt(cek, plaintext, aad []byte) (iv, ciphertext, tag []byte, err error)
+	Decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error)
+}
+
+func RegisterKeyAlgorithm(alg string, f func() KeyAlgorithm) {
+	keyAlgorithms[alg] = f
+}
+
+func GetKeyAlgorithm(alg string) (method KeyAlgorithm) {
+	if methodF, ok := keyAlgorithms[alg]; ok {
+		method = methodF()
+	}
+	return
+}
+
+func RegisterContentEncryption(enc string, f func() ContentEncryption) {
+	contentEncryptions[enc] = f
+}
+
+func GetContentEncryption(enc string) (method ContentEncryption) {
+	if methodF, ok := contentEncryptions[enc]; ok {
+		method = methodF()
+	}
+	return
+}
+
+var (
+	ErrJWEMalformed     = errors.New("JWE contains an invalid number of segments")
+	ErrJWEUnsupported   = errors.New("JWE alg or enc is unavailable")
+	ErrJWEDecryption    = errors.New("JWE could not be decrypted")
+	ErrKeyUnwrap        = errors.New("aes key unwrap integrity check failed")
+	ErrInvalidKeyLength = errors.New("key is of invalid length")
+)
+
+// JWE is a decrypted JWE compact token.
+type JWE struct {
+	Raw       string
+	Header    map[string]interface{}
+	Plaintext []byte
+}
+
+// EncryptJWE encrypts plaintext as a JWE compact token. Any header items
+// are added to the protected header alongside alg and enc.
+func EncryptJWE(plaintext []byte, alg KeyAlgorithm, enc ContentEncryption, key interface{}, header map[string]interface{}) (string, error) {
+	h := map[string]interface{}{}
+	for k, v := range header {
+		h[k] = v
+	}
+	h["alg"], h["enc"] = alg.Alg(), enc.Enc()
+
+	headerBytes, err := json.Marshal(h)
+	if err != nil {
+		return "", err
+	}
+	protected := EncodeSegment(headerBytes)
+
+	cek, encryptedKey, err := alg.EncryptKey(key, enc.KeySize())
+	if err != nil {
+		return "", err
+	}
+
+	iv, ciphertext, tag, err := enc.Encrypt(cek, plaintext, []byte(protected))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		protected,
+		EncodeSegment(encryptedKey),
+		EncodeSegment(iv),
+		EncodeSegment(ciphertext),
+		EncodeSegment(tag),
+	}, "."), nil
+}
+
+// DecryptJWE decrypts a JWE compact token with key, using the alg and enc
+// named by its protected header.
+func DecryptJWE(compact string, key interface{}) (*JWE, error) {
+	parts := strings.Split(compact, ".")
+	if len(parts) != 5 {
+		return nil, ErrJWEMalformed
+	}
+
+	jwe := &JWE{Raw: compact}
+	headerBytes, err := DecodeSegment(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(headerBytes, &jwe.Header); err != nil {
+		return nil, err
+	}
+
+	a, _ := jwe.Header["alg"].(string)
+	e, _ := jwe.Header["enc"].(string)
+	alg, enc := GetKeyAlgorithm(a), GetContentEncryption(e)
+	if alg == nil || enc == nil {
+		return jwe, ErrJWEUnsupported
+	}
+
+	var segments [4][]byte
+	for i := range segments {
+		if segments[i], err = DecodeSegment(parts[i+1]); err != nil {
+			return jwe, err
+		}
+	}
+
+	cek, err := alg.DecryptKey(segments[0], key, enc.KeySize())
+	if err != nil {
+		return jwe, err
+	}
+
+	if jwe.Plaintext, err = enc.Decrypt(cek, segments[1], segments[2], segments[3], []byte(parts[0])); err != nil {
+		return jwe, err
+	}
+	return jwe, nil
+}
+
+// IsJWE reports whether s has the five segments of a JWE compact token.
+func IsJWE(s string) bool {
+	return strings.Count(s, ".") == 4
+}
+
+// KeyAlgorithmDirect uses a shared symmetric key directly as the content
+// encryption key.
+type KeyAlgorithmDirect struct{}
+
+func (k *KeyAlgorithmDirect) Alg() string {
+	return "dir"
+}
+
+func (k *KeyAlgorithmDirect) EncryptKey(key interface{}, size int) ([]byte, []byte, error) {
+	cek, err := k.DecryptKey(nil, key, size)
+	return cek, []byte{}, err
+}
+
+func (k *KeyAlgorithmDirect) DecryptKey(encryptedKey []byte, key interface{}, size int) ([]byte, error) {
+	cek, ok := key.([]byte)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if len(cek) != size || len(encryptedKey) != 0 {
+		return nil, ErrInvalidKeyLength
+	}
+	return cek, nil
+}
+
+// KeyAlgorithmAESKW wraps a random content encryption key with AES Key Wrap
+// (RFC 3394).
+type KeyAlgorithmAESKW struct {
+	Name    string
+	KeySize int
+}
+
+func (k *KeyAlgorithmAESKW) Alg() string {
+	return k.Name
+}
+
+func (k *KeyAlgorithmAESKW) kek(key interface{}) (cipher.Block, error) {
+	kek, ok := key.([]byte)
+	if !ok {
+		return nil, ErrInvalidKey
+	}
+	if len(kek) != k.KeySize {
+		return nil, ErrInvalidKeyLength
+	}
+	return aes.NewCipher(kek)
+}
+
+func (k *KeyAlgorithmAESKW) EncryptKey(key interface{}, size int) ([]byte, []byte, error) {
+	block, err := k.kek(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+		return nil, nil, err
+	}
+	wrapped, err := keyWrap(block, cek)
+	return cek, wrapped, err
+}
+
+func (k *KeyAlgorithmAESKW) DecryptKey(encryptedKey []byte, key interface{}, size int) ([]byte, error) {
+	block, err := k.kek(key)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := keyUnwrap(block, encryptedKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(cek) != size {
+		return nil, ErrInvalidKeyLength
+	}
+	return cek, nil
+}
+
+var keyWrapIV = []byte{0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6, 0xa6}
+
+func keyWrap(block cipher.Block, cek []byte) ([]byte, error) {
+	if len(cek)%8 != 0 || len(cek) < 16 {
+		return nil, ErrInvalidKeyLength
+	}
+	n := len(cek) / 8
+	r := make([][]byte, n)
+	for i := range r {
+		r[i] = append([]byte(nil), cek[i*8:(i+1)*8]...)
+	}
+
+	a := append([]byte(nil), keyWrapIV...)
+	b := make([]byte, 16)
+	for j := 0; j < 6; j++ {
+		for i := 0; i < n; i++ {
+			copy(b, a)
+			copy(b[8:], r[i])
+			block.Encrypt(b, b)
+
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(a, binary.BigEndian.Uint64(b[:8])^t)
+			copy(r[i], b[8:])
+		}
+	}
+
+	out := append([]byte(nil), a...)
+	for _, ri := range r {
+		out = append(out, ri...)
+	}
+	return out, nil
+}
+
+func keyUnwrap(block cipher.Block, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, ErrInvalidKeyLength
+	}
+	n := len(wrapped)/8 - 1
+	r := make([][]byte, n)
+	for i := range r {
+		r[i] = append([]byte(nil), wrapped[(i+1)*8:(i+2)*8]...)
+	}
+
+	a := append([]byte(nil), wrapped[:8]...)
+	b := make([]byte, 16)
+	for j := 5; j >= 0; j-- {
+		for i := n - 1; i >= 0; i-- {
+			t := uint64(n*j + i + 1)
+			binary.BigEndian.PutUint64(b, binary.BigEndian.Uint64(a)^t)
+			copy(b[8:], r[i])
+			block.Decrypt(b, b)
+
+			copy(a, b[:8])
+			copy(r[i], b[8:])
+		}
+	}
+
+	if subtle.ConstantTimeCompare(a, keyWrapIV) != 1 {
+		return nil, ErrKeyUnwrap
+	}
+
+	out := make([]byte, 0, n*8)
+	for _, ri := range r {
+		out = append(out, ri...)
+	}
+	return out, nil
+}
+
+// KeyAlgorithmRSA encrypts a random content encryption key with
+// RSAES-OAEP using SHA-1, as specified for RSA-OAEP.
+type KeyAlgorithmRSA struct{}
+
+func (k *KeyAlgorithmRSA) Alg() string {
+	return "RSA-OAEP"
+}
+
+func (k *KeyAlgorithmRSA) EncryptKey(key interface{}, size int) ([]byte, []byte, error) {
+	var err error
+	var rsaKey *rsa.PublicKey
+
+	switch k := key.(type) {
+	case []byte:
+		if rsaKey, err = ParseRSAPublicKeyFromPEM(k); err != nil {
+			// a private key encrypts with its public half
+			private, perr := ParseRSAPrivateKeyFromPEM(k)
+			if perr != nil {
+				return nil, nil, err
+			}
+			rsaKey = &private.PublicKey
+		}
+	case *rsa.PublicKey:
+		rsaKey = k
+	case *rsa.PrivateKey:
+		rsaKey = &k.PublicKey
+	default:
+		return nil, nil, ErrInvalidKey
+	}
+
+	cek := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, cek); err != nil {
+		return nil, nil, err
+	}
+	encryptedKey, err := rsa.EncryptOAEP(sha1.New(), rand.Reader, rsaKey, cek, nil)
+	return cek, encryptedKey, err
+}
+
+func (k *KeyAlgorithmRSA) DecryptKey(encryptedKey []byte, key interface{}, size int) ([]byte, error) {
+	var err error
+	var rsaKey *rsa.PrivateKey
+
+	switch k := key.(type) {
+	case []byte:
+		if rsaKey, err = ParseRSAPrivateKeyFromPEM(k); err != nil {
+			return nil, err
+		}
+	case *rsa.PrivateKey:
+		rsaKey = k
+	default:
+		return nil, ErrInvalidKey
+	}
+
+	cek, err := rsa.DecryptOAEP(sha1.New(), rand.Reader, rsaKey, encryptedKey, nil)
+	if err != nil {
+		return nil, ErrJWEDecryption
+	}
+	if len(cek) != size {
+		return nil, ErrInvalidKeyLength
+	}
+	return cek, nil
+}
+
+// ContentEncryptionAESGCM implements the AES GCM content encryptions.
+type ContentEncryptionAESGCM struct {
+	Name string
+	Size int
+}
+
+func (c *ContentEncryptionAESGCM) Enc() string {
+	return c.Name
+}
+
+func (c *ContentEncryptionAESGCM) KeySize() int {
+	return c.Size
+}
+
+func (c *ContentEncryptionAESGCM) aead(cek []byte) (cipher.AEAD, error) {
+	if len(cek) != c.Size {
+		return nil, ErrInvalidKeyLength
+	}
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (c *ContentEncryptionAESGCM) Encrypt(cek, plaintext, aad []byte) ([]byte, []byte, []byte, error) {
+	gcm, err := c.aead(cek)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	iv := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, nil, nil, err
+	}
+	sealed := gcm.Seal(nil, iv, plaintext, aad)
+	split := len(sealed) - gcm.Overhead()
+	return iv, sealed[:split], sealed[split:], nil
+}
+
+func (c *ContentEncryptionAESGCM) Decrypt(cek, iv, ciphertext, tag, aad []byte) ([]byte, error) {
+	gcm, err := c.aead(cek)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return nil, ErrJWEDecryption
+	}
+	sealed := append(append([]byte(nil), ciphertext...), tag...)
+	plaintext, err := gcm.Open(nil, iv, sealed, aad)
+	if err != nil {
+		return nil, ErrJWEDecryption
+	}
+	return plaintext, nil
+}
+
+var (
+	KeyAlgorithmDir     *KeyAlgorithmDirect
+	KeyAlgorithmA128KW  *KeyAlgorithmAESKW
+	KeyAlgorithmA192KW  *KeyAlgorithmAESKW
+	KeyAlgorithmA256KW  *KeyAlgorithmAESKW
+	KeyAlgorithmRSAOAEP *KeyAlgorithmRSA
+	ContentA128GCM      *ContentEncryptionAESGCM
+	ContentA192GCM      *ContentEncryptionAESGCM
+	ContentA256GCM      *ContentEncryptionAESGCM
+)
+
+func init() {
+	// dir
+	KeyAlgorithmDir = &KeyAlgorithmDirect{}
+	RegisterKeyAlgorithm(KeyAlgorithmDir.Alg(), func() KeyAlgorithm {
+		return KeyAlgorithmDir
+	})
+
+	// A128KW
+	KeyAlgorithmA128KW = &KeyAlgorithmAESKW{"A128KW", 16}
+	RegisterKeyAlgorithm(KeyAlgorithmA128KW.Alg(), func() KeyAlgorithm {
+		return KeyAlgorithmA128KW
+	})
+
+	// A192KW
+	KeyAlgorithmA192KW = &KeyAlgorithmAESKW{"A192KW", 24}
+	RegisterKeyAlgorithm(KeyAlgorithmA192KW.Alg(), func() KeyAlgorithm {
+		return KeyAlgorithmA192KW
+	})
+
+	// A256KW
+	KeyAlgorithmA256KW = &KeyAlgorithmAESKW{"A256KW", 32}
+	RegisterKeyAlgorithm(KeyAlgorithmA256KW.Alg(), func() KeyAlgorithm {
+		return KeyAlgorithmA256KW
+	})
+
+	// RSA-OAEP
+	KeyAlgorithmRSAOAEP = &KeyAlgorithmRSA{}
+	RegisterKeyAlgorithm(KeyAlgorithmRSAOAEP.Alg(), func() KeyAlgorithm {
+		return KeyAlgorithmRSAOAEP
+	})
+
+	// A128GCM
+	ContentA128GCM = &ContentEncryptionAESGCM{"A128GCM", 16}
+	RegisterContentEncryption(ContentA128GCM.Enc(), func() ContentEncryption {
+		return ContentA128GCM
+	})
+
+	// A192GCM
+	ContentA192GCM = &ContentEncryptionAESGCM{"A192GCM", 24}
+	RegisterContentEncryption(ContentA192GCM.Enc(), func() ContentEncryption {
+		return ContentA192GCM
+	})
+
+	// A256GCM
+	ContentA256GCM = &ContentEncryptionAESGCM{"A256GCM", 32}
+	RegisterContentEncryption(ContentA256GCM.Enc(), func() ContentEncryption {
+		return ContentA256GCM
+	})
+}
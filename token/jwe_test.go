@@ -0,0 +1,192 @@
+package token
+
+import (
+	"bytes"
+	"crypto/aes"
+	"encoding/hex"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+var jweTestData = []struct {
+	name string
+	alg  string
+	enc  string
+	key  []byte
+}{
+	{"dir A128GCM", "dir", "A128GCM", []byte("1234567890abcdeF")},
+	{"dir A256GCM", "dir", "A256GCM", []byte("1234567890abcdeF1234567890abcdeF")},
+	{"A128KW A128GCM", "A128KW", "A128GCM", []byte("1234567890abcdeF")},
+	{"A128KW A256GCM", "A128KW", "A256GCM", []byte("1234567890abcdeF")},
+	{"A256KW A256GCM", "A256KW", "A256GCM", []byte("1234567890abcdeF1234567890abcdeF")},
+}
+
+func TestJWERoundTrip(t *testing.T) {
+	plaintext := []byte("eyJhbGciOiJIUzI1NiJ9.eyJmb28iOiJiYXIifQ.c2ln")
+	for _, data := range jweTestData {
+		alg, enc := GetKeyAlgorithm(data.alg), GetContentEncryption(data.enc)
+		compact, err := EncryptJWE(plaintext, alg, enc, data.key, map[string]interface{}{"cty": "JWT"})
+		if err != nil {
+			t.Errorf("[%v] Error encrypting: %v", data.name, err)
+			continue
+		}
+		if len(strings.Split(compact, ".")) != 5 {
+			t.Errorf("[%v] Expected five segments, got %s", data.name, compact)
+		}
+		jwe, err := DecryptJWE(compact, data.key)
+		if err != nil {
+			t.Errorf("[%v] Error decrypting: %v", data.name, err)
+			continue
+		}
+		if !bytes.Equal(jwe.Plaintext, plaintext) {
+			t.Errorf("[%v] Plaintext mismatch: %s", data.name, jwe.Plaintext)
+		}
+		if jwe.Header["alg"] != data.alg || jwe.Header["enc"] != data.enc || jwe.Header["cty"] != "JWT" {
+			t.Errorf("[%v] Unexpected header: %v", data.name, jwe.Header)
+		}
+	}
+}
+
+func TestJWETampered(t *testing.T) {
+	key := []byte("1234567890abcdeF")
+	compact, _ := EncryptJWE([]byte("payload"), KeyAlgorithmA128KW, ContentA128GCM, key, nil)
+	parts := strings.Split(compact, ".")
+
+	for i := range parts {
+		tampered := append([]string(nil), parts...)
+		if tampered[i][0] == 'A' {
+			tampered[i] = "B" + tampered[i][1:]
+		} else {
+			tampered[i] = "A" + tampered[i][1:]
+		}
+		if _, err := DecryptJWE(strings.Join(tampered, "."), key); err == nil {
+			t.Errorf("Tampering with segment %d was not detected", i)
+		}
+	}
+
+	if _, err := DecryptJWE(compact, []byte("0987654321abcdeF")); err == nil {
+		t.Errorf("Expected an error decrypting with the wrong key")
+	}
+
+	if _, err := DecryptJWE(strings.Join(parts[:3], "."), key); err != ErrJWEMalformed {
+		t.Errorf("Expected ErrJWEMalformed, but was %v", err)
+	}
+}
+
+func TestJWERSAOAEP(t *testing.T) {
+	privateKey, _ := ioutil.ReadFile("resources/sample_key")
+	publicKey, _ := ioutil.ReadFile("resources/sample_key.pub")
+
+	for _, enc := range []ContentEncryption{ContentA128GCM, ContentA256GCM} {
+		compact, err := EncryptJWE([]byte("payload"), KeyAlgorithmRSAOAEP, enc, publicKey, nil)
+		if err != nil {
+			t.Errorf("[RSA-OAEP %s] Error encrypting: %v", enc.Enc(), err)
+			continue
+		}
+		jwe, err := DecryptJWE(compact, privateKey)
+		if err != nil || string(jwe.Plaintext) != "payload" {
+			t.Errorf("[RSA-OAEP %s] Error decrypting: %v", enc.Enc(), err)
+		}
+		if _, err := DecryptJWE(compact, publicKey); err == nil {
+			t.Errorf("[RSA-OAEP %s] Decrypted with a public key", enc.Enc())
+		}
+		compact, err = EncryptJWE([]byte("payload"), KeyAlgorithmRSAOAEP, enc, privateKey, nil)
+		if err != nil {
+			t.Errorf("[RSA-OAEP %s] Error encrypting with a private key PEM: %v", enc.Enc(), err)
+			continue
+		}
+		if jwe, err := DecryptJWE(compact, privateKey); err != nil || string(jwe.Plaintext) != "payload" {
+			t.Errorf("[RSA-OAEP %s] Error decrypting: %v", enc.Enc(), err)
+		}
+	}
+}
+
+func TestAESKeyWrap(t *testing.T) {
+	// RFC 3394, 4.1 Wrap 128 bits of Key Data with a 128-bit KEK
+	kek, _ := hex.DecodeString("000102030405060708090A0B0C0D0E0F")
+	cek, _ := hex.DecodeString("00112233445566778899AABBCCDDEEFF")
+	expected, _ := hex.DecodeString("1FA68B0A8112B447AEF34BD8FB5A7B829D3E862371D2CFE5")
+
+	block, _ := aes.NewCipher(kek)
+	wrapped, err := keyWrap(block, cek)
+	if err != nil || !bytes.Equal(wrapped, expected) {
+		t.Errorf("Expected wrapped key %x, but was %x (%v)", expected, wrapped, err)
+	}
+
+	unwrapped, err := keyUnwrap(block, wrapped)
+	if err != nil || !bytes.Equal(unwrapped, cek) {
+		t.Errorf("Expected unwrapped key %x, but was %x (%v)", cek, unwrapped, err)
+	}
+
+	wrapped[0] ^= 1
+	if _, err := keyUnwrap(block, wrapped); err != ErrKeyUnwrap {
+		t.Errorf("Expected ErrKeyUnwrap, but was %v", err)
+	}
+}
+
+func TestSignatoryJWE(t *testing.T) {
+	key := "1234567890abcdeF"
+	s := NewSignatory("TEST", "", key, NewSigner("HS256", "secret"), WithJWE("dir", "", nil))
+
+	tkn := s.SignedWith(Claims{"foo": "bar"})
+	if !IsJWE(tkn) {
+		t.Fatalf("Expected a JWE compact token, got %s", tkn)
+	}
+
+	// the payload is a signed token any JWE library can recover with the key
+	jwe, err := DecryptJWE(tkn, []byte(key))
+	if err != nil {
+		t.Fatalf("Error decrypting signatory token: %v", err)
+	}
+	if jwe.Header["enc"] != "A128GCM" || jwe.Header["cty"] != "JWT" {
+		t.Errorf("Unexpected header: %v", jwe.Header)
+	}
+	if _, err := Parse(string(jwe.Plaintext), s.Keyfunc()); err != nil {
+		t.Errorf("Error parsing decrypted token: %v", err)
+	}
+
+	valid, err := s.Valid(tkn)
+	if err != nil || valid.Claims["foo"] != "bar" {
+		t.Errorf("Expected valid JWE signatory token, but was %v", err)
+	}
+
+	kw := NewSignatory("TEST", "", key, NewSigner("HS256", "secret"), WithJWE("A128KW", "A256GCM", nil))
+	if _, err := kw.Valid(tkn); err == nil {
+		t.Errorf("Expected a signatory configured for A128KW to reject a dir token")
+	}
+
+	plain := NewSignatory("TEST", "", key, NewSigner("HS256", "secret"))
+	if _, err := plain.Valid(tkn); err == nil {
+		t.Errorf("Expected a signatory without JWE to reject a JWE token")
+	}
+	if _, err := s.Valid(plain.SignedWith(Claims{"foo": "bar"})); err != nil {
+		t.Errorf("Expected a JWE signatory to still read AES-GCM envelopes, but was %v", err)
+	}
+}
+
+func TestCheckJWE(t *testing.T) {
+	for _, c := range []struct {
+		alg, enc, key string
+		valid         bool
+	}{
+		{"dir", "", "1234567890abcdeF", true},
+		{"dir", "", "1234567890abcdeF12345678", true},
+		{"A128KW", "A256GCM", "1234567890abcdeF", true},
+		{"dir", "A256GCM", "1234567890abcdeF", false},
+		{"A256KW", "A128GCM", "1234567890abcdeF", false},
+		{"RSA-OAEP", "A128GCM", "1234567890abcdeF", false},
+		{"A512KW", "", "1234567890abcdeF", false},
+		{"dir", "A512GCM", "1234567890abcdeF", false},
+	} {
+		err := CheckJWE(c.alg, c.enc, nil, c.key)
+		if c.valid != (err == nil) {
+			t.Errorf("[%s %s with a %d byte key] expected valid %t, but was %v", c.alg, c.enc, len(c.key), c.valid, err)
+		}
+		// a signatory given refused options mints nothing rather than panic
+		s := NewSignatory("TEST", "", c.key, NewSigner("HS256", "secret"), WithJWE(c.alg, c.enc, nil))
+		if tkn := s.SignedWith(nil); c.valid == (tkn == "") {
+			t.Errorf("[%s %s with a %d byte key] unexpected token %q", c.alg, c.enc, len(c.key), tkn)
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	ErrJWKUnsupported = errors.New("JWK key type or curve is unsupported")
+	ErrJWKNotFound    = errors.New("no JWK matches the token kid and alg")
+)
+
+// JWK is a public JSON Web Key, as published in a JWK Set.
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is a JWK Set document.
+type JWKSet struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// KeySet is a Signer able to publish its verification keys. Symmetric
+// signers publish nothing.
+type KeySet interface {
+	JWKSet() *JWKSet
+}
+
+// NewJWK returns the public JWK for an RSA, ECDSA or Ed25519 public key.
+func NewJWK(kid, alg string, key interface{}) (*JWK, error) {
+	j := &JWK{Kid: kid, Use: "sig", Alg: alg}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		j.Kty = "RSA"
+		j.N = EncodeSegment(k.N.Bytes())
+		j.E = EncodeSegment(big.NewInt(int64(k.E)).Bytes())
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		j.Kty, j.Crv = "EC", k.Curve.Params().Name
+		j.X = EncodeSegment(padded(k.X.Bytes(), size))
+		j.Y = EncodeSegment(padded(k.Y.Bytes(), size))
+	case ed25519.PublicKey:
+		j.Kty, j.Crv = "OKP", "Ed25519"
+		j.X = EncodeSegment(k)
+	default:
+		return nil, ErrJWKUnsupported
+	}
+	return j, nil
+}
+
+func padded(b []byte, size int) []byte {
+	out := make([]byte, size)
+	copy(out[size-len(b):], b)
+	return out
+}
+
+// PublicKey returns the key described by j.
+func (j *JWK) PublicKey() (interface{}, error) {
+	switch j.Kty {
+	case "RSA":
+		n, err := DecodeSegment(j.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := DecodeSegment(j.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch j.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, ErrJWKUnsupported
+		}
+		x, err := DecodeSegment(j.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := DecodeSegment(j.Y)
+		if err != nil {
+			return nil, err
+		}
+		k := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(k.X, k.Y) {
+			return nil, ErrNotECPublicKey
+		}
+		return k, nil
+	case "OKP":
+		if j.Crv != "Ed25519" {
+			return nil, ErrJWKUnsupported
+		}
+		x, err := DecodeSegment(j.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, ErrNotEdPublicKey
+		}
+		return ed25519.PublicKey(x), nil
+	}
+	return nil, ErrJWKUnsupported
+}
+
+// Add appends keys to the set, skipping any whose kid is already present.
+func (s *JWKSet) Add(keys ...*JWK) {
+	for _, k := range keys {
+		if s.Key(k.Kid, "") == nil {
+			s.Keys = append(s.Keys, k)
+		}
+	}
+}
+
+// Key returns the key with the given kid, usable with alg when alg is set.
+func (s *JWKSet) Key(kid, alg string) *JWK {
+	for _, k := range s.Keys {
+		if k.Kid == kid && (alg == "" || k.Alg == "" || k.Alg == alg) {
+			return k
+		}
+	}
+	return nil
+}
+
+func (s *signer) JWKSet() *JWKSet {
+	set := &JWKSet{Keys: []*JWK{}}
+	if j, err := NewJWK("", s.Alg(), s.verify); err == nil {
+		set.Add(j)
+	}
+	return set
+}
+
+// JWKSet publishes the keys of the signatory Signer, when it is a KeySet.
+func (s *signatory) JWKSet() *JWKSet {
+	if ks, ok := s.Signer.(KeySet); ok {
+		return ks.JWKSet()
+	}
+	return &JWKSet{Keys: []*JWK{}}
+}
+
+// JWKSet publishes the keyring keys still accepted for verification.
+func (k *Keyring) JWKSet() *JWKSet {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	set := &JWKSet{Keys: []*JWK{}}
+	for kid, rk := range k.keys {
+		if !rk.expires.IsZero() && TimeFunc().After(rk.expires) {
+			continue
+		}
+		if j, err := NewJWK(kid, k.Alg(), rk.verify); err == nil {
+			set.Add(j)
+		}
+	}
+	return set
+}
+
+// JWKSFetcher retrieves a JWK Set document.
+type JWKSFetcher func() ([]byte, error)
+
+// JWKSBytes is a JWKSFetcher for an in-memory document.
+func JWKSBytes(document []byte) JWKSFetcher {
+	return func() ([]byte, error) {
+		return document, nil
+	}
+}
+
+// JWKSFile is a JWKSFetcher reading a document from path.
+func JWKSFile(path string) JWKSFetcher {
+	return func() ([]byte, error) {
+		return ioutil.ReadFile(path)
+	}
+}
+
+// JWKSURL is a JWKSFetcher requesting a document from url with client, or
+// http.DefaultClient when client is nil.
+func JWKSURL(url string, client *http.Client) JWKSFetcher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return func() ([]byte, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, errors.New("JWK Set request failed: " + resp.Status)
+		}
+		return ioutil.ReadAll(resp.Body)
+	}
+}
+
+// JWKSRefetchInterval is the least time between JWK Set fetches triggered by
+// tokens naming an unknown kid.
+var JWKSRefetchInterval = time.Minute
+
+// JWKSKeyfunc returns a Keyfunc resolving verification keys by kid from the
+// JWK Set fetch retrieves. The set is fetched on first use, and again when
+// a token names a kid it does not contain, at most once per
+// JWKSRefetchInterval.
+func JWKSKeyfunc(fetch JWKSFetcher) Keyfunc {
+	var mu sync.Mutex
+	var set *JWKSet
+	var fetched time.Time
+
+	load := func() error {
+		document, err := fetch()
+		if err != nil {
+			return err
+		}
+		s := &JWKSet{}
+		if err = json.Unmarshal(document, s); err != nil {
+			return err
+		}
+		set, fetched = s, TimeFunc()
+		return nil
+	}
+
+	return func(t *Token) (interface{}, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		kid, _ := t.Header["kid"].(string)
+		alg, _ := t.Header["alg"].(string)
+		if set == nil || (set.Key(kid, alg) == nil && TimeFunc().Sub(fetched) >= JWKSRefetchInterval) {
+			if err := load(); err != nil {
+				return nil, err
+			}
+		}
+		j := set.Key(kid, alg)
+		if j == nil {
+			return nil, ErrJWKNotFound
+		}
+		return j.PublicKey()
+	}
+}
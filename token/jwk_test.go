@@ -0,0 +1,143 @@
+package token
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var jwkTestData = []struct {
+	name   string
+	alg    string
+	key    string
+	kty    string
+	crv    string
+	signed bool
+}{
+	{"RS256", "RS256", "sample_key", "RSA", "", true},
+	{"ES256", "ES256", "ec256_key", "EC", "P-256", true},
+	{"ES384", "ES384", "ec384_key", "EC", "P-384", true},
+	{"ES512", "ES512", "ec512_key", "EC", "P-521", true},
+	{"EdDSA", "EdDSA", "ed25519_key", "OKP", "Ed25519", true},
+	{"HS256", "HS256", "hmacTestKey", "", "", false},
+}
+
+func TestJWKSetPublish(t *testing.T) {
+	for _, data := range jwkTestData {
+		key, _ := ioutil.ReadFile("resources/" + data.key)
+		set := NewSigner(data.alg, string(key)).(KeySet).JWKSet()
+		if !data.signed {
+			if len(set.Keys) != 0 {
+				t.Errorf("[%v] Published a symmetric key: %v", data.name, set.Keys)
+			}
+			continue
+		}
+		if len(set.Keys) != 1 {
+			t.Errorf("[%v] Expected one key, got %d", data.name, len(set.Keys))
+			continue
+		}
+		j := set.Keys[0]
+		if j.Kty != data.kty || j.Crv != data.crv || j.Alg != data.alg || j.Use != "sig" {
+			t.Errorf("[%v] Unexpected JWK: %+v", data.name, j)
+		}
+		if _, err := j.PublicKey(); err != nil {
+			t.Errorf("[%v] Error reading published key: %v", data.name, err)
+		}
+	}
+}
+
+func TestJWKSKeyfunc(t *testing.T) {
+	for _, data := range jwkTestData {
+		if !data.signed {
+			continue
+		}
+		key, _ := ioutil.ReadFile("resources/" + data.key)
+		s := NewSignatory("TEST", "", "1234567890abcdeF", NewKeyring(data.alg, "k-"+data.name, string(key)))
+		document, _ := json.Marshal(s.(KeySet).JWKSet())
+
+		signed, _ := s.TokenWith(Claims{"foo": "bar"}).SignedString(s.Key())
+		tkn, err := Parse(signed, JWKSKeyfunc(JWKSBytes(document)))
+		if err != nil || !tkn.Valid {
+			t.Errorf("[%v] Expected token verified from JWK Set, but was %v", data.name, err)
+		}
+	}
+}
+
+func TestJWKSKeyfuncFetchers(t *testing.T) {
+	key, _ := ioutil.ReadFile("resources/ec256_key")
+	kr := NewKeyring("ES256", "first", string(key))
+	signed, _ := NewSignatory("TEST", "", "1234567890abcdeF", kr).TokenWith(nil).SignedString(kr.Key())
+	document, _ := json.Marshal(kr.JWKSet())
+
+	dir, _ := ioutil.TempDir("", "jwks")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "jwks.json")
+	ioutil.WriteFile(path, document, 0600)
+
+	fetches := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(document)
+	}))
+	defer server.Close()
+
+	for name, fetch := range map[string]JWKSFetcher{
+		"file": JWKSFile(path),
+		"url":  JWKSURL(server.URL, server.Client()),
+	} {
+		kf := JWKSKeyfunc(fetch)
+		for i := 0; i < 2; i++ {
+			if _, err := Parse(signed, kf); err != nil {
+				t.Errorf("[%v] Expected token verified from JWK Set, but was %v", name, err)
+			}
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("Expected the JWK Set to be fetched once, but was fetched %d times", fetches)
+	}
+
+	if _, err := Parse(signed, JWKSKeyfunc(JWKSFile(filepath.Join(dir, "missing.json")))); err == nil {
+		t.Errorf("Expected an error for a missing JWK Set")
+	}
+}
+
+func TestJWKSKeyfuncUnknownKid(t *testing.T) {
+	defer func() { TimeFunc = time.Now }()
+
+	key, _ := ioutil.ReadFile("resources/ec256_key")
+	kr := NewKeyring("ES256", "first", string(key))
+	s := NewSignatory("TEST", "", "1234567890abcdeF", kr)
+
+	fetches := 0
+	kf := JWKSKeyfunc(func() ([]byte, error) {
+		fetches++
+		return json.Marshal(kr.JWKSet())
+	})
+
+	first, _ := s.TokenWith(nil).SignedString(kr.Key())
+	if _, err := Parse(first, kf); err != nil {
+		t.Errorf("Expected token verified from JWK Set, but was %v", err)
+	}
+
+	rotated, _ := ioutil.ReadFile("resources/ec384_key")
+	kr = NewKeyring("ES384", "second", string(rotated))
+	s = NewSignatory("TEST", "", "1234567890abcdeF", kr)
+	second, _ := s.TokenWith(nil).SignedString(kr.Key())
+
+	if _, err := Parse(second, kf); err == nil {
+		t.Errorf("Expected unknown kid to fail within the refetch interval")
+	}
+
+	TimeFunc = func() time.Time { return time.Now().Add(JWKSRefetchInterval) }
+	if _, err := Parse(second, kf); err != nil {
+		t.Errorf("Expected unknown kid to refetch the JWK Set, but was %v", err)
+	}
+	if fetches != 2 {
+		t.Errorf("Expected two fetches, got %d", fetches)
+	}
+}
@@ -0,0 +1,133 @@
+package token
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrUnknownKeyID = errors.New("token kid does not name a known key")
+	ErrKeyRetired   = errors.New("token kid names a key retired past its grace period")
+)
+
+// KeyIdentified is a Signer that names its current signing key, which a
+// Signatory stamps as the kid header of new tokens.
+type KeyIdentified interface {
+	KeyID() string
+}
+
+// Keyring is a Signer holding several keys by kid. New tokens are signed
+// with the current key, and verified with whichever key their kid header
+// names; retired keys verify only until their grace period ends.
+type Keyring struct {
+	SigningMethod
+	mu      sync.RWMutex
+	current string
+	keys    map[string]*ringKey
+}
+
+type ringKey struct {
+	key     []byte
+	verify  interface{}
+	expires time.Time
+}
+
+// NewKeyring returns a Keyring signing with key, identified by kid.
+func NewKeyring(method, kid, key string) *Keyring {
+	k := &Keyring{
+		SigningMethod: GetSigningMethod(method),
+		keys:          make(map[string]*ringKey),
+	}
+	k.add(kid, key, time.Time{})
+	k.current = kid
+	return k
+}
+
+func (k *Keyring) add(kid, key string, expires time.Time) {
+	k.keys[kid] = &ringKey{
+		key:     []byte(key),
+		verify:  verificationKey(k.SigningMethod, []byte(key)),
+		expires: expires,
+	}
+}
+
+// Rotate makes key, identified by kid, the current signing key. The previous
+// key is retired, verifying outstanding tokens for the grace period.
+func (k *Keyring) Rotate(kid, key string, grace time.Duration) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if prev, ok := k.keys[k.current]; ok && k.current != kid {
+		prev.expires = TimeFunc().Add(grace)
+	}
+	k.add(kid, key, time.Time{})
+	k.current = kid
+}
+
+// Retire adds a verify-only key, identified by kid, accepted until the given
+// time. Retiring the current key is a no-op.
+func (k *Keyring) Retire(kid, key string, until time.Time) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if kid == k.current {
+		return
+	}
+	k.add(kid, key, until)
+}
+
+func (k *Keyring) KeyID() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+func (k *Keyring) Key() []byte {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.keys[k.current].key
+}
+
+func (rk *ringKey) expired() bool {
+	return !rk.expires.IsZero() && TimeFunc().After(rk.expires)
+}
+
+// Keyfunc returns the verification key named by a token kid header. Tokens
+// without a kid, signed before keys were identified, are verified with the
+// current key or whichever retired key in its grace period signed them.
+func (k *Keyring) Keyfunc() Keyfunc {
+	return func(t *Token) (interface{}, error) {
+		k.mu.RLock()
+		defer k.mu.RUnlock()
+		kid, ok := t.Header["kid"].(string)
+		if !ok {
+			return k.unidentified(t), nil
+		}
+		rk, ok := k.keys[kid]
+		if !ok {
+			return nil, ErrUnknownKeyID
+		}
+		if rk.expired() {
+			return nil, ErrKeyRetired
+		}
+		return rk.verify, nil
+	}
+}
+
+func (k *Keyring) unidentified(t *Token) interface{} {
+	current := k.keys[k.current].verify
+	parts := strings.Split(t.Raw, ".")
+	if len(parts) != 3 || t.Method == nil {
+		return current
+	}
+	signing := strings.Join(parts[:2], ".")
+	if t.Method.Verify(signing, parts[2], current) == nil {
+		return current
+	}
+	for kid, rk := range k.keys {
+		if kid != k.current && !rk.expired() && t.Method.Verify(signing, parts[2], rk.verify) == nil {
+			return rk.verify
+		}
+	}
+	return current
+}
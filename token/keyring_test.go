@@ -0,0 +1,123 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	defer func() { TimeFunc = time.Now }()
+
+	kr := NewKeyring("HS256", "2015-01", "first-secret")
+	s := NewSignatory("TEST", "", "1234567890abcdeF", kr)
+
+	old := s.SignedWith(Claims{"foo": "bar"})
+	tkn, err := s.Valid(old)
+	if err != nil {
+		t.Fatalf("Expected valid token before rotation, but was %v", err)
+	}
+	if tkn.Header["kid"] != "2015-01" {
+		t.Errorf("Expected kid header 2015-01, but was %v", tkn.Header["kid"])
+	}
+
+	kr.Rotate("2015-02", "second-secret", time.Hour)
+	if kr.KeyID() != "2015-02" || string(kr.Key()) != "second-secret" {
+		t.Errorf("Expected rotation to make 2015-02 current, but was %s", kr.KeyID())
+	}
+
+	current := s.SignedWith(Claims{"foo": "bar"})
+	if tkn, err = s.Valid(current); err != nil || tkn.Header["kid"] != "2015-02" {
+		t.Errorf("Expected valid token signed with the new key, but was %v", err)
+	}
+	if _, err = s.Valid(old); err != nil {
+		t.Errorf("Expected token signed with a retired key to verify during grace, but was %v", err)
+	}
+
+	TimeFunc = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	_, err = s.Valid(old)
+	if e, ok := err.(*ValidationError); !ok || e.Errors&ValidationErrorUnverifiable == 0 {
+		t.Errorf("Expected token signed with an expired key to be unverifiable, but was %v", err)
+	}
+	TimeFunc = time.Now
+
+	other := NewSignatory("TEST", "", "1234567890abcdeF", NewKeyring("HS256", "2015-03", "other-secret"))
+	if _, err = other.Valid(current); err == nil {
+		t.Errorf("Expected token with an unknown kid to be rejected")
+	}
+}
+
+func TestKeyringRetire(t *testing.T) {
+	old := NewSignatory("TEST", "", "1234567890abcdeF", NewKeyring("HS256", "a", "first-secret"))
+	tkn := old.SignedWith(nil)
+
+	kr := NewKeyring("HS256", "b", "second-secret")
+	kr.Retire("a", "first-secret", time.Now().Add(time.Hour))
+	s := NewSignatory("TEST", "", "0987654321abcdeF", kr, WithRetiredKeys("1234567890abcdeF"))
+	if _, err := s.Valid(tkn); err != nil {
+		t.Errorf("Expected token from retired keys to be valid, but was %v", err)
+	}
+
+	kr.Retire("b", "ignored", time.Now())
+	if string(kr.Key()) != "second-secret" {
+		t.Errorf("Expected retiring the current key to be a no-op")
+	}
+
+	expired := NewKeyring("HS256", "b", "second-secret")
+	expired.Retire("a", "first-secret", time.Now().Add(-time.Hour))
+	s = NewSignatory("TEST", "", "0987654321abcdeF", expired, WithRetiredKeys("1234567890abcdeF"))
+	if _, err := s.Valid(tkn); err == nil {
+		t.Errorf("Expected token from expired keys to be rejected")
+	}
+
+	s = NewSignatory("TEST", "", "0987654321abcdeF", kr)
+	if _, err := s.Valid(tkn); err == nil {
+		t.Errorf("Expected token sealed with an unknown encryption key to be rejected")
+	}
+}
+
+func TestKeyringUnidentified(t *testing.T) {
+	defer func() { TimeFunc = time.Now }()
+
+	plain := NewSignatory("TEST", "", "1234567890abcdeF", NewSigner("HS256", "first-secret"))
+	tkn := plain.SignedWith(Claims{"foo": "bar"})
+
+	kr := NewKeyring("HS256", "2015-02", "second-secret")
+	kr.Retire("2015-01", "first-secret", time.Now().Add(time.Hour))
+	s := NewSignatory("TEST", "", "0987654321abcdeF", kr,
+		WithRetiredKey("1234567890abcdeF", time.Now().Add(time.Hour)))
+	if _, err := s.Valid(tkn); err != nil {
+		t.Errorf("Expected token signed before keys had a kid to verify with the retired key, but was %v", err)
+	}
+	if _, err := s.Valid(s.SignedWith(nil)); err != nil {
+		t.Errorf("Expected token signed with the current key to verify, but was %v", err)
+	}
+
+	TimeFunc = func() time.Time { return time.Now().Add(2 * time.Hour) }
+	if _, err := s.Valid(tkn); err == nil {
+		t.Errorf("Expected token sealed and signed with expired keys to be rejected")
+	}
+}
+
+func TestKeyringAsymmetric(t *testing.T) {
+	first, _ := ioutil.ReadFile("resources/ec256_key")
+	kr := NewKeyring("ES256", "ec", string(first))
+	s := NewSignatory("TEST", "", "1234567890abcdeF", kr, WithJWE("dir", "", nil))
+	tkn := s.SignedWith(nil)
+
+	k, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalECPrivateKey(k)
+	second := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+	kr.Rotate("ec-2", string(second), time.Hour)
+	if _, err := s.Valid(s.SignedWith(nil)); err != nil {
+		t.Errorf("Expected token signed with the rotated ES256 key to verify, but was %v", err)
+	}
+	if _, err := s.Valid(tkn); err != nil {
+		t.Errorf("Expected token signed with the retired ES256 key to verify, but was %v", err)
+	}
+}
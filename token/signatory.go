@@ -4,6 +4,7 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	cr "crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -15,19 +16,134 @@ import (
 type Signatory interface {
 	Name() string
 	Token(...string) *Token
+	TokenWith(Claims) *Token
 	Valid(string) (*Token, error)
+	ValidWith(string, *ValidationOptions) (*Token, error)
+	Consume(string) (*Token, error)
+	Revoke(jti string, until time.Time) error
 	SignedString(...string) string
+	SignedWith(Claims) string
 	Signer
 }
 
-func NewSignatory(name, timestamp, key string, sr Signer) Signatory {
-	return &signatory{
+// SignatoryOption configures a Signatory on creation.
+type SignatoryOption func(*signatory)
+
+// WithValidation sets the options a signatory validates tokens against. An
+// Issuer or Audience is also stamped as the iss or aud claim of new tokens.
+func WithValidation(o *ValidationOptions) SignatoryOption {
+	return func(s *signatory) {
+		s.options = o
+	}
+}
+
+// WithLegacyEnvelopes lets a signatory read the unauthenticated AES-CFB
+// envelopes written by earlier versions, for use while migrating.
+func WithLegacyEnvelopes() SignatoryOption {
+	return func(s *signatory) {
+		s.legacyEnvelopes = true
+	}
+}
+
+// WithTokenStore has a signatory reject tokens whose jti the store records
+// as spent. Tokens without a jti are then rejected too.
+func WithTokenStore(ts TokenStore) SignatoryOption {
+	return func(s *signatory) {
+		s.store = ts
+	}
+}
+
+// WithRetiredKeys lets a signatory open tokens sealed with previous
+// encryption keys, tried in turn after its own, so the encryption key can be
+// rotated without invalidating outstanding tokens.
+func WithRetiredKeys(keys ...string) SignatoryOption {
+	return func(s *signatory) {
+		for _, key := range keys {
+			s.retired = append(s.retired, retiredKey{key: mkEncryptionKey(key)})
+		}
+	}
+}
+
+// WithRetiredKey lets a signatory open tokens sealed with a previous
+// encryption key until the given time, as WithRetiredKeys.
+func WithRetiredKey(key string, until time.Time) SignatoryOption {
+	return func(s *signatory) {
+		s.retired = append(s.retired, retiredKey{mkEncryptionKey(key), until})
+	}
+}
+
+// retiredKey is a previous encryption key, opening tokens until a time, or
+// for good when that is zero.
+type retiredKey struct {
+	key   []byte
+	until time.Time
+}
+
+// WithJWE has a signatory emit JWE compact tokens, encrypted with the named
+// key management and content encryption algorithms, in place of its own
+// AES-GCM envelope. A nil key uses the signatory encryption keys, as suits
+// dir and the AES key wraps; an empty enc picks the AES-GCM variant matching
+// the length of the encryption key. Options CheckJWE refuses leave the
+// signatory minting no tokens.
+func WithJWE(alg, enc string, key interface{}) SignatoryOption {
+	return func(s *signatory) {
+		s.jwe, s.jweErr = newJWEOptions(alg, enc, key, s.key)
+	}
+}
+
+// CheckJWE returns why WithJWE options cannot encrypt tokens alongside the
+// signatory encryption key given, or nil when they can, so a configuration
+// is refused before any token is minted.
+func CheckJWE(alg, enc string, key interface{}, encryptionKey string) error {
+	switch len(encryptionKey) {
+	case 16, 24, 32:
+	default:
+		return ErrInvalidKeyLength
+	}
+	_, err := newJWEOptions(alg, enc, key, []byte(encryptionKey))
+	return err
+}
+
+type jweOptions struct {
+	alg KeyAlgorithm
+	enc ContentEncryption
+	key interface{}
+}
+
+func newJWEOptions(alg, enc string, key interface{}, secret []byte) (*jweOptions, error) {
+	if enc == "" {
+		enc = fmt.Sprintf("A%dGCM", len(secret)*8)
+	}
+	o := &jweOptions{alg: GetKeyAlgorithm(alg), enc: GetContentEncryption(enc), key: key}
+	if o.alg == nil {
+		return nil, fmt.Errorf("JWE alg %q is unavailable", alg)
+	}
+	if o.enc == nil {
+		return nil, fmt.Errorf("JWE enc %q is unavailable", enc)
+	}
+	if key == nil {
+		key = secret
+	}
+	if _, err := EncryptJWE(nil, o.alg, o.enc, key, nil); err != nil {
+		return nil, fmt.Errorf("JWE %s with %s cannot use the key given: %v", alg, enc, err)
+	}
+	return o, nil
+}
+
+// NewSignatory returns a Signatory issuing tokens with NumericDate times.
+// The timestamp layout is only used to read legacy tokens, see
+// ValidationOptions.Legacy.
+func NewSignatory(name, timestamp, key string, sr Signer, opts ...SignatoryOption) Signatory {
+	s := &signatory{
 		name:            name,
 		timestampFormat: timestamp,
-		timestampClaim:  fmt.Sprintf("tsf:%s", timestamp),
 		key:             mkEncryptionKey(key),
 		Signer:          sr,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
 }
 
 func mkEncryptionKey(key string) []byte {
@@ -45,8 +161,13 @@ func mkEncryptionKey(key string) []byte {
 type signatory struct {
 	name            string
 	timestampFormat string
-	timestampClaim  string
 	key             []byte
+	options         *ValidationOptions
+	retired         []retiredKey
+	store           TokenStore
+	legacyEnvelopes bool
+	jwe             *jweOptions
+	jweErr          error
 	Signer
 }
 
@@ -54,68 +175,278 @@ func (s *signatory) Name() string {
 	return s.name
 }
 
+// Token is a thin adapter over TokenWith for "key:value" claim strings.
 func (s *signatory) Token(items ...string) *Token {
+	return s.TokenWith(StringClaims(items...))
+}
+
+func (s *signatory) TokenWith(claims Claims) *Token {
 	tkn := New(s)
-	items = append(items, s.timestampClaim, s.iat())
-	mkClaims(tkn, items)
+	for k, v := range claims {
+		tkn.Claims[k] = v
+	}
+	tkn.Claims["iat"] = NumericDate(TimeFunc())
+	tkn.Claims["jti"] = NewTokenID()
+	if ki, ok := s.Signer.(KeyIdentified); ok {
+		tkn.Header["kid"] = ki.KeyID()
+	}
+	if s.options != nil {
+		if s.options.Issuer != "" {
+			tkn.Claims["iss"] = s.options.Issuer
+		}
+		if s.options.Audience != "" {
+			tkn.Claims["aud"] = s.options.Audience
+		}
+	}
 	return tkn
 }
 
-func (s *signatory) iat() string {
-	return fmt.Sprintf("iat:%s", time.Now().Format(s.timestampFormat))
+// SignedString is a thin adapter over SignedWith for "key:value" claim
+// strings.
+func (s *signatory) SignedString(claims ...string) string {
+	return s.SignedWith(StringClaims(claims...))
 }
 
-func (s *signatory) SignedString(claims ...string) string {
-	signed, err := s.Token(claims...).SignedString(s.Signer.Key())
+func (s *signatory) SignedWith(claims Claims) string {
+	signed, err := s.TokenWith(claims).SignedString(s.Signer.Key())
 	if err != nil {
 		return err.Error()
 	}
 	return s.Encrypt(signed)
 }
 
-func mkClaims(t *Token, items []string) {
-	for _, v := range items {
-		sp := strings.Split(v, ":")
-		if len(sp) == 2 {
-			t.Claims[sp[0]] = sp[1]
+func (s *signatory) Valid(token string) (*Token, error) {
+	return s.ValidWith(token, nil)
+}
+
+// ValidWith validates token against the signatory's own options, with any
+// set fields of opts taking precedence. Only tokens signed with the signer's
+// own algorithm are accepted unless Algorithms are given.
+//
+// Legacy envelopes predate the iss, aud and jti claims, so neither issuer
+// nor audience is checked for them; a legacy token without a jti is given
+// one derived from the token, so it can still be consumed once.
+func (s *signatory) ValidWith(token string, opts *ValidationOptions) (*Token, error) {
+	tkn, legacy, err := s.open(token)
+	if err != nil {
+		return nil, err
+	}
+	o := s.options.merge(opts)
+	if o.LegacyFormat == "" {
+		o.LegacyFormat = s.timestampFormat
+	}
+	if len(o.Algorithms) == 0 {
+		o.Algorithms = []string{s.Alg()}
+	}
+	switch {
+	case legacy:
+		o.Issuer, o.Audience = "", ""
+	case s.store != nil:
+		o.Required = append(o.Required, "jti")
+	}
+	t, err := ParseWithOptions(tkn, s.Signer.Keyfunc(), o)
+	if err != nil {
+		return t, err
+	}
+	if _, ok := t.Claims["jti"]; legacy && !ok {
+		t.Claims["jti"] = legacyTokenID(token)
+	}
+	if s.store == nil {
+		return t, nil
+	}
+	if spent, err := s.store.Spent(claimJTI(t)); err != nil || spent {
+		return t, spentError(err)
+	}
+	return t, nil
+}
+
+// Consume validates token and marks it spent, so it is valid only once.
+// Without a TokenStore it is the same as Valid.
+func (s *signatory) Consume(token string) (*Token, error) {
+	t, err := s.Valid(token)
+	if err != nil || s.store == nil {
+		return t, err
+	}
+	if ok, err := s.store.Spend(claimJTI(t), Expiry(t)); err != nil || !ok {
+		return t, spentError(err)
+	}
+	return t, nil
+}
+
+// Revoke marks the token id jti spent until the given time, returning
+// ErrTokenSpent when it already was.
+func (s *signatory) Revoke(jti string, until time.Time) error {
+	if s.store == nil {
+		return nil
+	}
+	ok, err := s.store.Spend(jti, until)
+	if err == nil && !ok {
+		err = ErrTokenSpent
+	}
+	return err
+}
+
+// legacyTokenID identifies a legacy token by a digest of its envelope.
+func legacyTokenID(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "legacy-" + base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func claimJTI(t *Token) string {
+	jti, _ := t.Claims["jti"].(string)
+	return jti
+}
+
+func spentError(err error) error {
+	if err == nil {
+		err = ErrTokenSpent
+	}
+	return &ValidationError{err: err.Error(), Errors: ValidationErrorSpent}
+}
+
+// envelopeVersion prefixes tokens sealed with AES-GCM. Unprefixed tokens
+// are the legacy AES-CFB envelopes, which carry no integrity check.
+const envelopeVersion = "v2."
+
+// keys are the encryption keys a token may have been sealed with, current
+// first, leaving out retired keys past their time.
+func (s *signatory) keys() [][]byte {
+	keys, now := [][]byte{s.key}, TimeFunc()
+	for _, r := range s.retired {
+		if r.until.IsZero() || now.Before(r.until) {
+			keys = append(keys, r.key)
 		}
 	}
+	return keys
 }
 
-func (s *signatory) Valid(token string) (*Token, error) {
-	tkn, err := s.Decrypt(token)
+func aead(key []byte) (cipher.AEAD, error) {
+	c, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	return Parse(tkn, s.Signer.Keyfunc())
+	return cipher.NewGCM(c)
 }
 
+// Encrypt seals tokenString with AES-GCM, binding it to the signatory name,
+// or as a JWE when the signatory was created WithJWE. It returns an empty
+// string, which never validates, when the token cannot be sealed.
 func (s *signatory) Encrypt(tokenString string) string {
-	c, err := aes.NewCipher([]byte(s.key))
+	if s.jweErr != nil {
+		return ""
+	}
+	if s.jwe != nil {
+		key := s.jwe.key
+		if key == nil {
+			key = s.key
+		}
+		out, err := EncryptJWE([]byte(tokenString), s.jwe.alg, s.jwe.enc, key, map[string]interface{}{"cty": "JWT"})
+		if err != nil {
+			return ""
+		}
+		return out
+	}
+	gcm, err := aead(s.key)
 	if err != nil {
-		panic(err.Error())
+		return ""
 	}
-	out := make([]byte, aes.BlockSize+len(tokenString))
-	iv := out[:aes.BlockSize]
-	if _, err := io.ReadFull(cr.Reader, iv); err != nil {
-		panic(err)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(cr.Reader, nonce); err != nil {
+		return ""
 	}
-	cfb := cipher.NewCFBEncrypter(c, iv)
-	cfb.XORKeyStream(out[aes.BlockSize:], []byte(tokenString))
-	return base64.URLEncoding.EncodeToString(out)
+	out := gcm.Seal(nonce, nonce, []byte(tokenString), []byte(s.name))
+	return envelopeVersion + base64.RawURLEncoding.EncodeToString(out)
 }
 
+func decryptionError(err error) error {
+	return &ValidationError{err: err.Error(), Errors: ValidationErrorDecryption}
+}
+
+// Decrypt opens a token sealed by Encrypt, or a legacy AES-CFB token when
+// the signatory was created WithLegacyEnvelopes.
 func (s *signatory) Decrypt(tokenString string) (string, error) {
+	out, _, err := s.open(tokenString)
+	return out, err
+}
+
+// open decrypts tokenString, reporting whether it was a legacy envelope.
+func (s *signatory) open(tokenString string) (string, bool, error) {
+	if IsJWE(tokenString) {
+		out, err := s.decryptJWE(tokenString)
+		return out, false, err
+	}
+	if !strings.HasPrefix(tokenString, envelopeVersion) {
+		if s.legacyEnvelopes {
+			out, err := s.decryptLegacy(tokenString)
+			return out, true, err
+		}
+		return "", false, decryptionError(ErrEnvelopeVersion)
+	}
+	out, err := s.decryptSealed(tokenString)
+	return out, false, err
+}
+
+func (s *signatory) decryptSealed(tokenString string) (string, error) {
+	tkn, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(tokenString, envelopeVersion))
+	if err != nil {
+		return "", decryptionError(err)
+	}
+	for _, key := range s.keys() {
+		var gcm cipher.AEAD
+		if gcm, err = aead(key); err != nil {
+			return "", decryptionError(err)
+		}
+		if len(tkn) < gcm.NonceSize() {
+			return "", decryptionError(ErrTokenLength)
+		}
+		var out []byte
+		if out, err = gcm.Open(nil, tkn[:gcm.NonceSize()], tkn[gcm.NonceSize():], []byte(s.name)); err == nil {
+			return string(out), nil
+		}
+	}
+	return "", decryptionError(err)
+}
+
+// decryptJWE opens a JWE, accepting only the algorithms the signatory was
+// configured with.
+func (s *signatory) decryptJWE(tokenString string) (string, error) {
+	if s.jwe == nil {
+		return "", decryptionError(ErrEnvelopeVersion)
+	}
+	keys := []interface{}{s.jwe.key}
+	if s.jwe.key == nil {
+		keys = keys[:0]
+		for _, key := range s.keys() {
+			keys = append(keys, key)
+		}
+	}
+	var jwe *JWE
+	var err error
+	for _, key := range keys {
+		if jwe, err = DecryptJWE(tokenString, key); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return "", decryptionError(err)
+	}
+	if jwe.Header["alg"] != s.jwe.alg.Alg() || jwe.Header["enc"] != s.jwe.enc.Enc() {
+		return "", decryptionError(ErrJWEUnsupported)
+	}
+	return string(jwe.Plaintext), nil
+}
+
+func (s *signatory) decryptLegacy(tokenString string) (string, error) {
 	tkn, err := base64.URLEncoding.DecodeString(tokenString)
 	if err != nil {
-		return "", err
+		return "", decryptionError(err)
 	}
-	c, err := aes.NewCipher([]byte(s.key))
+	c, err := aes.NewCipher(s.key)
 	if err != nil {
-		return "", err
+		return "", decryptionError(err)
 	}
 	if len(tkn) < aes.BlockSize {
-		return "", ErrTokenLength
+		return "", decryptionError(ErrTokenLength)
 	}
 	iv := tkn[:aes.BlockSize]
 	tkn = tkn[aes.BlockSize:]
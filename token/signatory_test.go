@@ -1,6 +1,8 @@
 package token
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -21,7 +23,126 @@ func TestSignatory(t *testing.T) {
 		}
 	}
 	_, err = s.Valid(testSignatoryToken)
+	if err == nil {
+		t.Errorf("Existing legacy test token was valid without legacy envelopes")
+	}
+	l := NewSignatory("TEST", time.UnixDate, "abcdefghijklmnop", NewSigner("HS256", "SIGNER-KEY"), WithLegacyEnvelopes())
+	_, err = l.Valid(testSignatoryToken)
 	if err != nil {
 		t.Errorf("Existing test token was not valid with created Signatory")
 	}
+	if _, err = l.Valid(tknOut); err != nil {
+		t.Errorf("Expected signatory token to be valid with legacy envelopes, but was not: %s", err.Error())
+	}
+	m := NewSignatory(
+		"TEST",
+		time.UnixDate,
+		"abcdefghijklmnop",
+		NewSigner("HS256", "SIGNER-KEY"),
+		WithLegacyEnvelopes(),
+		WithValidation(&ValidationOptions{Issuer: "one", Audience: "TEST"}),
+		WithTokenStore(NewMemoryStore()),
+	)
+	if _, err = m.Consume(testSignatoryToken); err != nil {
+		t.Errorf("Expected legacy token without iss, aud or jti to be valid, but was not: %s", err.Error())
+	}
+	if _, err = m.Consume(testSignatoryToken); err == nil {
+		t.Errorf("Expected consumed legacy token to be spent")
+	}
+}
+
+func TestSignatoryTampered(t *testing.T) {
+	s := NewSignatory("TEST", time.UnixDate, "abcdefghijklmnop", NewSigner("HS256", "SIGNER-KEY"))
+	tkn := []byte(s.SignedString("TESTING:TRUE"))
+	i := len(tkn) / 2
+	if tkn[i] == 'A' {
+		tkn[i] = 'B'
+	} else {
+		tkn[i] = 'A'
+	}
+	_, err := s.Valid(string(tkn))
+	if err == nil {
+		t.Fatal("Expected tampered signatory token to be invalid")
+	}
+	if vErr, ok := err.(*ValidationError); !ok || vErr.Errors != ValidationErrorDecryption {
+		t.Errorf("Expected a decryption ValidationError, but was %v", err)
+	}
+	other := NewSignatory("OTHER", time.UnixDate, "abcdefghijklmnop", NewSigner("HS256", "SIGNER-KEY"))
+	if _, err := other.Valid(s.SignedString("TESTING:TRUE")); err == nil {
+		t.Error("Expected signatory token to be invalid for another signatory")
+	}
+}
+
+func TestSignatoryValidation(t *testing.T) {
+	mk := func(issuer string) Signatory {
+		return NewSignatory(
+			"TEST",
+			time.UnixDate,
+			"abcdefghijklmnop",
+			NewSigner("HS256", "SIGNER-KEY"),
+			WithValidation(&ValidationOptions{Issuer: issuer, Audience: "TEST"}),
+		)
+	}
+	one, two := mk("one"), mk("two")
+	tkn := one.SignedString("sub:user")
+	if _, err := one.Valid(tkn); err != nil {
+		t.Errorf("Expected token to be valid for its issuing signatory: %s", err.Error())
+	}
+	if _, err := two.Valid(tkn); err == nil {
+		t.Error("Expected token from another issuer to be invalid")
+	}
+	if _, err := one.ValidWith(tkn, &ValidationOptions{Subject: "other"}); err == nil {
+		t.Error("Expected token for another subject to be invalid")
+	}
+}
+
+func TestSignatoryNumericDate(t *testing.T) {
+	s := NewSignatory("TEST", time.UnixDate, "abcdefghijklmnop", NewSigner("HS256", "SIGNER-KEY"))
+	exp := NumericDate(time.Now().Add(time.Hour))
+	tkn, err := s.Valid(s.SignedString(fmt.Sprintf("exp:%d", exp)))
+	if err != nil {
+		t.Fatalf("Expected signatory token to be valid, but was not: %s", err.Error())
+	}
+	if tkn.Claims["exp"] != float64(exp) {
+		t.Errorf("Expected numeric exp claim %d, but was %v", exp, tkn.Claims["exp"])
+	}
+	if _, ok := tkn.Claims["iat"].(float64); !ok {
+		t.Errorf("Expected numeric iat claim, but was %v", tkn.Claims["iat"])
+	}
+	expired := s.SignedString(fmt.Sprintf("exp:%d", NumericDate(time.Now().Add(-time.Hour))))
+	if _, err := s.Valid(expired); err == nil {
+		t.Error("Expected expired signatory token to be invalid")
+	}
+}
+
+func TestSignatoryClaims(t *testing.T) {
+	s := NewSignatory("TEST", time.UnixDate, "abcdefghijklmnop", NewSigner("HS256", "SIGNER-KEY"))
+	tkn, err := s.Valid(s.SignedWith(Claims{
+		"count":  3,
+		"admin":  true,
+		"roles":  []string{"a", "b"},
+		"nested": map[string]interface{}{"url": "http://example.com:8080/x"},
+	}))
+	if err != nil {
+		t.Fatalf("Expected signatory token to be valid, but was not: %s", err.Error())
+	}
+	expected := map[string]interface{}{
+		"count":  float64(3),
+		"admin":  true,
+		"roles":  []interface{}{"a", "b"},
+		"nested": map[string]interface{}{"url": "http://example.com:8080/x"},
+	}
+	for k, v := range expected {
+		if !reflect.DeepEqual(tkn.Claims[k], v) {
+			t.Errorf("Expected claim %s to be %v, but was %v", k, v, tkn.Claims[k])
+		}
+	}
+}
+
+func TestStringClaims(t *testing.T) {
+	c := StringClaims("at:15:04:05", "url:http://example.com", "exp:100", "dropped")
+	expected := Claims{"at": "15:04:05", "url": "http://example.com", "exp": int64(100)}
+	if !reflect.DeepEqual(c, expected) {
+		t.Errorf("Expected string claims %v, but were %v", expected, c)
+	}
 }
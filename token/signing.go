@@ -19,6 +19,13 @@ func GetSigningMethod(alg string) (method SigningMethod) {
 	return
 }
 
+// Symmetric reports whether the named signing method signs and verifies
+// with the same shared secret.
+func Symmetric(alg string) bool {
+	_, ok := GetSigningMethod(alg).(*SigningMethodHMAC)
+	return ok
+}
+
 type Signer interface {
 	SigningMethod
 	Key() []byte
@@ -26,15 +33,38 @@ type Signer interface {
 }
 
 func NewSigner(method, key string) Signer {
+	m := GetSigningMethod(method)
 	return &signer{
-		SigningMethod: GetSigningMethod(method),
+		SigningMethod: m,
 		key:           []byte(key),
+		verify:        verificationKey(m, []byte(key)),
 	}
 }
 
 type signer struct {
 	SigningMethod
-	key []byte
+	key    []byte
+	verify interface{}
+}
+
+// verificationKey returns the public half of a PEM encoded private key for
+// asymmetric signing methods, and the key unchanged for anything else.
+func verificationKey(method SigningMethod, key []byte) interface{} {
+	switch method.(type) {
+	case *SigningMethodRSA, *SigningMethodRSAPSS:
+		if k, err := ParseRSAPrivateKeyFromPEM(key); err == nil {
+			return &k.PublicKey
+		}
+	case *SigningMethodECDSA:
+		if k, err := ParseECPrivateKeyFromPEM(key); err == nil {
+			return &k.PublicKey
+		}
+	case *SigningMethodEd25519:
+		if k, err := ParseEdPrivateKeyFromPEM(key); err == nil {
+			return k.Public()
+		}
+	}
+	return key
 }
 
 func (s *signer) Key() []byte {
@@ -43,6 +73,6 @@ func (s *signer) Key() []byte {
 
 func (s *signer) Keyfunc() Keyfunc {
 	return func(*Token) (interface{}, error) {
-		return s.key, nil
+		return s.verify, nil
 	}
 }
@@ -0,0 +1,134 @@
+package token
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var ErrTokenSpent = errors.New("token was already used or revoked")
+
+// TokenStore records spent token ids, those consumed or revoked, so a
+// Signatory can reject replayed tokens. Ids need only be kept until the
+// given time, after which the token expires anyway; a zero time keeps an id
+// indefinitely.
+type TokenStore interface {
+	// Spend marks jti spent, reporting false when it already was.
+	Spend(jti string, until time.Time) (bool, error)
+	// Spent reports whether jti was consumed or revoked.
+	Spent(jti string) (bool, error)
+}
+
+// NewTokenID returns a random token id for the jti claim.
+func NewTokenID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return EncodeSegment(b)
+}
+
+// Expiry returns the time of the exp claim of t, or the zero time when it
+// has none.
+func Expiry(t *Token) time.Time {
+	if exp, ok, err := claimTime(t, "exp", &ValidationOptions{}); ok && err == nil {
+		return exp
+	}
+	return time.Time{}
+}
+
+type spentIDs map[string]time.Time
+
+func (s spentIDs) prune() {
+	now := TimeFunc()
+	for jti, until := range s {
+		if !until.IsZero() && now.After(until) {
+			delete(s, jti)
+		}
+	}
+}
+
+// MemoryStore is a TokenStore for a single process.
+type MemoryStore struct {
+	mu    sync.Mutex
+	spent spentIDs
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{spent: make(spentIDs)}
+}
+
+func (m *MemoryStore) Spend(jti string, until time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spent.prune()
+	if _, ok := m.spent[jti]; ok {
+		return false, nil
+	}
+	m.spent[jti] = until
+	return true, nil
+}
+
+func (m *MemoryStore) Spent(jti string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spent.prune()
+	_, ok := m.spent[jti]
+	return ok, nil
+}
+
+// FileStore is a TokenStore persisting spent ids as JSON to a file, so they
+// survive restarts.
+type FileStore struct {
+	path string
+	MemoryStore
+}
+
+// NewFileStore returns a FileStore at path, loading any ids already there.
+func NewFileStore(path string) (*FileStore, error) {
+	f := &FileStore{path: path, MemoryStore: MemoryStore{spent: make(spentIDs)}}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(b, &f.spent); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileStore) Spend(jti string, until time.Time) (bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.spent.prune()
+	if _, ok := f.spent[jti]; ok {
+		return false, nil
+	}
+	f.spent[jti] = until
+	if err := f.save(); err != nil {
+		delete(f.spent, jti)
+		return false, err
+	}
+	return true, nil
+}
+
+// save writes the ids to a temporary file renamed over path, so a crash
+// never leaves a partial file.
+func (f *FileStore) save() error {
+	b, err := json.Marshal(f.spent)
+	if err != nil {
+		return err
+	}
+	tmp := f.path + ".tmp"
+	if err = ioutil.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, f.path)
+}
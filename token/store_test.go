@@ -0,0 +1,92 @@
+package token
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testSingleUse(t *testing.T, name string, ts TokenStore) {
+	s := NewSignatory("TEST", "", "1234567890abcdeF", NewSigner("HS256", "secret"), WithTokenStore(ts))
+	tkn := s.SignedWith(Claims{"exp": NumericDate(time.Now().Add(time.Hour))})
+
+	for i := 0; i < 2; i++ {
+		if _, err := s.Valid(tkn); err != nil {
+			t.Errorf("[%v] Expected unspent token to be valid, but was %v", name, err)
+		}
+	}
+	if _, err := s.Consume(tkn); err != nil {
+		t.Errorf("[%v] Expected first use to succeed, but was %v", name, err)
+	}
+	for _, f := range []func(string) (*Token, error){s.Consume, s.Valid} {
+		_, err := f(tkn)
+		if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorSpent {
+			t.Errorf("[%v] Expected ValidationErrorSpent for a replayed token, but was %v", name, err)
+		}
+	}
+
+	revoked := s.SignedWith(nil)
+	parsed, _ := s.Valid(revoked)
+	if err := s.Revoke(parsed.Claims["jti"].(string), time.Time{}); err != nil {
+		t.Errorf("[%v] Error revoking token: %v", name, err)
+	}
+	if err := s.Revoke(parsed.Claims["jti"].(string), time.Time{}); err != ErrTokenSpent {
+		t.Errorf("[%v] Expected ErrTokenSpent revoking twice, but was %v", name, err)
+	}
+	if _, err := s.Valid(revoked); err == nil {
+		t.Errorf("[%v] Expected revoked token to be rejected", name)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testSingleUse(t, "memory", NewMemoryStore())
+}
+
+func TestFileStore(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "tokens")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spent.json")
+
+	fs, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Error creating file store: %v", err)
+	}
+	testSingleUse(t, "file", fs)
+
+	fs.Spend("kept", time.Time{})
+	fs.Spend("expiring", time.Now().Add(time.Minute))
+
+	reopened, err := NewFileStore(path)
+	if err != nil {
+		t.Fatalf("Error reopening file store: %v", err)
+	}
+	for _, jti := range []string{"kept", "expiring"} {
+		if spent, _ := reopened.Spent(jti); !spent {
+			t.Errorf("Expected %s to be spent after reopening the store", jti)
+		}
+	}
+
+	defer func() { TimeFunc = time.Now }()
+	TimeFunc = func() time.Time { return time.Now().Add(time.Hour) }
+	if spent, _ := reopened.Spent("expiring"); spent {
+		t.Errorf("Expected an expired id to be pruned")
+	}
+	if spent, _ := reopened.Spent("kept"); !spent {
+		t.Errorf("Expected an id kept indefinitely to remain spent")
+	}
+}
+
+func TestTokenStoreRequiresJTI(t *testing.T) {
+	signer := NewSigner("HS256", "secret")
+	s := NewSignatory("TEST", "", "1234567890abcdeF", signer, WithTokenStore(NewMemoryStore())).(*signatory)
+
+	tkn := New(signer)
+	tkn.Claims["foo"] = "bar"
+	signed, _ := tkn.SignedString(signer.Key())
+	_, err := s.Valid(s.Encrypt(signed))
+	if e, ok := err.(*ValidationError); !ok || e.Errors&ValidationErrorClaimRequired == 0 {
+		t.Errorf("Expected a token without jti to be rejected, but was %v", err)
+	}
+}
@@ -67,7 +67,18 @@ func (t *Token) SigningString() (string, error) {
 	return strings.Join(parts, "."), nil
 }
 
+// Parse parses and verifies tokenString with any registered signing method
+// but none. HMAC methods refuse PEM encoded keys, so a token naming HS256 is
+// never checked against a public key; callers whose keyFunc does not check
+// the token Method should still use ParseWithOptions, giving
+// ValidationOptions.Algorithms.
 func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
+	return ParseWithOptions(tokenString, keyFunc, nil)
+}
+
+// ParseWithOptions parses and verifies tokenString, additionally checking
+// the registered claims described by opts.
+func ParseWithOptions(tokenString string, keyFunc Keyfunc, opts *ValidationOptions) (*Token, error) {
 	parts := strings.Split(tokenString, ".")
 	if len(parts) != 3 {
 		return nil, &ValidationError{err: "token contains an invalid number of segments", Errors: ValidationErrorMalformed}
@@ -95,6 +106,9 @@ func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
 
 	// Lookup signature method
 	if method, ok := token.Header["alg"].(string); ok {
+		if !opts.allows(method) {
+			return token, &ValidationError{err: "signing method (alg) is not allowed.", Errors: ValidationErrorAlgorithm}
+		}
 		if token.Method = GetSigningMethod(method); token.Method == nil {
 			return token, &ValidationError{err: "signing method (alg) is unavailable.", Errors: ValidationErrorUnverifiable}
 		}
@@ -113,40 +127,9 @@ func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
 		return token, &ValidationError{err: err.Error(), Errors: ValidationErrorUnverifiable}
 	}
 
-	// Check expiration times
+	// Check registered claims
 	vErr := &ValidationError{}
-	now := TimeFunc()
-	var format string
-	format, ok := token.Claims["tsf"].(string)
-	if !ok {
-		format = time.UnixDate
-	}
-	if exp, ok := token.Claims["exp"].(string); ok {
-		expires, err := time.ParseInLocation(format, exp, TimeZone)
-		if err != nil {
-			vErr.err = err.Error()
-			vErr.Errors |= ValidationErrorInvalidTime
-		}
-		if err == nil {
-			if now.After(expires) {
-				vErr.err = "token is expired"
-				vErr.Errors |= ValidationErrorExpired
-			}
-		}
-	}
-	if nbf, ok := token.Claims["nbf"].(string); ok {
-		before, err := time.ParseInLocation(format, nbf, TimeZone)
-		if err != nil {
-			vErr.err = err.Error()
-			vErr.Errors |= ValidationErrorInvalidTime
-		}
-		if err == nil {
-			if now.Before(before) {
-				vErr.err = "token is not valid yet"
-				vErr.Errors |= ValidationErrorNotValidYet
-			}
-		}
-	}
+	opts.validate(token, vErr)
 
 	// Perform validation
 	if err = token.Method.Verify(strings.Join(parts[0:2], "."), parts[2], key); err != nil {
@@ -161,19 +144,27 @@ func Parse(tokenString string, keyFunc Keyfunc) (*Token, error) {
 	return token, vErr
 }
 
+// ParseFromRequest parses and verifies the bearer token or access_token
+// parameter of req, accepting any algorithm as Parse does.
 func ParseFromRequest(req *http.Request, keyFunc Keyfunc) (token *Token, err error) {
+	return ParseFromRequestWithOptions(req, keyFunc, nil)
+}
+
+// ParseFromRequestWithOptions is ParseFromRequest checking the token as
+// ParseWithOptions does.
+func ParseFromRequestWithOptions(req *http.Request, keyFunc Keyfunc, opts *ValidationOptions) (token *Token, err error) {
 	// Look for an Authorization header
 	if ah := req.Header.Get("Authorization"); ah != "" {
 		// Should be a bearer token
 		if len(ah) > 6 && strings.ToUpper(ah[0:6]) == "BEARER" {
-			return Parse(ah[7:], keyFunc)
+			return ParseWithOptions(ah[7:], keyFunc, opts)
 		}
 	}
 
 	// Look for "access_token" parameter
 	req.ParseMultipartForm(10e6)
 	if tokStr := req.Form.Get("access_token"); tokStr != "" {
-		return Parse(tokStr, keyFunc)
+		return ParseWithOptions(tokStr, keyFunc, opts)
 	}
 
 	return nil, ErrNoTokenInRequest
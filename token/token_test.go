@@ -1,6 +1,8 @@
 package token
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -91,16 +93,24 @@ var tokenTestData = []struct {
 	},
 }
 
-func tMinus(val int) string {
+func tMinus(val int) float64 {
 	n := time.Now()
-	nt := n.Truncate(time.Duration(val) * time.Minute)
-	return nt.Format(time.UnixDate)
+	nt := n.Add(-time.Duration(val) * time.Minute)
+	return float64(NumericDate(nt))
 }
 
-func tPlus(val int) string {
+func tPlus(val int) float64 {
 	n := time.Now()
 	nt := n.Add(time.Duration(val) * time.Minute)
-	return nt.Format(time.UnixDate)
+	return float64(NumericDate(nt))
+}
+
+func legacyMinus(val int) string {
+	return time.Now().Add(-time.Duration(val) * time.Minute).Format(time.UnixDate)
+}
+
+func legacyPlus(val int) string {
+	return time.Now().Add(time.Duration(val) * time.Minute).Format(time.UnixDate)
 }
 
 func mkTokenString(c map[string]interface{}) string {
@@ -147,6 +157,174 @@ func TestToken(t *testing.T) {
 	}
 }
 
+var validationTestData = []struct {
+	name    string
+	claims  map[string]interface{}
+	options *ValidationOptions
+	errors  uint32
+}{
+	{
+		"issuer",
+		map[string]interface{}{"iss": "one"},
+		&ValidationOptions{Issuer: "one"},
+		0,
+	},
+	{
+		"wrong issuer",
+		map[string]interface{}{"iss": "two"},
+		&ValidationOptions{Issuer: "one"},
+		ValidationErrorIssuer,
+	},
+	{
+		"audience string",
+		map[string]interface{}{"aud": "app"},
+		&ValidationOptions{Audience: "app"},
+		0,
+	},
+	{
+		"audience array",
+		map[string]interface{}{"aud": []interface{}{"other", "app"}},
+		&ValidationOptions{Audience: "app"},
+		0,
+	},
+	{
+		"wrong audience",
+		map[string]interface{}{"aud": []interface{}{"other"}},
+		&ValidationOptions{Audience: "app"},
+		ValidationErrorAudience,
+	},
+	{
+		"wrong subject",
+		map[string]interface{}{"sub": "someone"},
+		&ValidationOptions{Subject: "user"},
+		ValidationErrorSubject,
+	},
+	{
+		"required claims",
+		map[string]interface{}{"jti": "1"},
+		&ValidationOptions{Required: []string{"jti", "sub"}},
+		ValidationErrorClaimRequired,
+	},
+	{
+		"expired within leeway",
+		map[string]interface{}{"exp": tMinus(100)},
+		&ValidationOptions{Leeway: 200 * time.Minute},
+		0,
+	},
+	{
+		"legacy string times rejected",
+		map[string]interface{}{"exp": legacyPlus(100)},
+		nil,
+		ValidationErrorInvalidTime,
+	},
+	{
+		"legacy string times",
+		map[string]interface{}{"exp": legacyPlus(100), "tsf": time.UnixDate},
+		&ValidationOptions{Legacy: true},
+		0,
+	},
+	{
+		"legacy expired and nbf",
+		map[string]interface{}{"exp": legacyMinus(100), "nbf": legacyPlus(100)},
+		&ValidationOptions{Legacy: true},
+		ValidationErrorNotValidYet | ValidationErrorExpired,
+	},
+	{
+		"legacy numeric times",
+		map[string]interface{}{"exp": tMinus(100)},
+		&ValidationOptions{Legacy: true},
+		ValidationErrorExpired,
+	},
+	{
+		"issuer and audience",
+		map[string]interface{}{"iss": "two", "aud": "other"},
+		&ValidationOptions{Issuer: "one", Audience: "app"},
+		ValidationErrorIssuer | ValidationErrorAudience,
+	},
+	{
+		"allowed algorithm",
+		map[string]interface{}{"foo": "bar"},
+		&ValidationOptions{Algorithms: []string{"PS256", "RS256"}},
+		0,
+	},
+	{
+		"disallowed algorithm",
+		map[string]interface{}{"foo": "bar"},
+		&ValidationOptions{Algorithms: []string{"ES256"}},
+		ValidationErrorAlgorithm,
+	},
+}
+
+func TestParseWithOptions(t *testing.T) {
+	for _, data := range validationTestData {
+		tokenString := mkTokenString(data.claims)
+		_, err := ParseWithOptions(tokenString, defaultKeyFunc, data.options)
+		if data.errors == 0 && err != nil {
+			t.Errorf("[%v] Error while verifying token: %v", data.name, err)
+		}
+		if data.errors != 0 {
+			if err == nil {
+				t.Errorf("[%v] Expecting error.  Didn't get one.", data.name)
+			} else if err.(*ValidationError).Errors != data.errors {
+				t.Errorf("[%v] Errors don't match expectation: %b", data.name, err.(*ValidationError).Errors)
+			}
+		}
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	// an HS256 token using the RS256 public key as its secret
+	hs := New(SigningMethodHS256)
+	hs.Claims["foo"] = "bar"
+	if _, err := hs.SignedString(testPublicKey); err != ErrInvalidKey {
+		t.Errorf("Expected HS256 to refuse to sign with a PEM key, but was %v", err)
+	}
+	signing, _ := hs.SigningString()
+	mac := hmac.New(sha256.New, testPublicKey)
+	mac.Write([]byte(signing))
+	forged := signing + "." + EncodeSegment(mac.Sum(nil))
+
+	_, err := Parse(forged, defaultKeyFunc)
+	if e, ok := err.(*ValidationError); !ok || e.Errors&ValidationErrorSignatureInvalid == 0 {
+		t.Errorf("Expected the forged token to fail verification without an allow-list, but was %v", err)
+	}
+	_, err = ParseWithOptions(forged, defaultKeyFunc, &ValidationOptions{Algorithms: []string{"RS256"}})
+	if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorAlgorithm {
+		t.Errorf("Expected ValidationErrorAlgorithm, but was %v", err)
+	}
+	r, _ := http.NewRequest("GET", "/", nil)
+	r.Header.Set("Authorization", fmt.Sprintf("Bearer %v", forged))
+	_, err = ParseFromRequestWithOptions(r, defaultKeyFunc, &ValidationOptions{Algorithms: []string{"RS256"}})
+	if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorAlgorithm {
+		t.Errorf("Expected ValidationErrorAlgorithm from the request, but was %v", err)
+	}
+
+	key, _ := ioutil.ReadFile("resources/sample_key")
+	s := NewSignatory("TEST", "", "1234567890abcdeF", NewSigner("RS256", string(key))).(*signatory)
+	_, err = s.Valid(s.Encrypt(forged))
+	if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorAlgorithm {
+		t.Errorf("Expected signatory to reject HS256 token with ValidationErrorAlgorithm, but was %v", err)
+	}
+	if _, err = s.ValidWith(s.Encrypt(forged), &ValidationOptions{Algorithms: []string{"HS256"}}); err == nil {
+		t.Errorf("Expected HS256 verification with an RSA public key to fail")
+	}
+}
+
+func TestAlgorithmNone(t *testing.T) {
+	for _, alg := range []string{"none", "None", "NONE"} {
+		header := EncodeSegment([]byte(`{"alg":"` + alg + `","typ":"JWT"}`))
+		claims := EncodeSegment([]byte(`{"foo":"bar"}`))
+		_, err := Parse(header+"."+claims+".", emptyKeyFunc)
+		if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorAlgorithm {
+			t.Errorf("[%v] Expected ValidationErrorAlgorithm, but was %v", alg, err)
+		}
+		_, err = ParseWithOptions(header+"."+claims+".", emptyKeyFunc, &ValidationOptions{Algorithms: []string{alg}})
+		if e, ok := err.(*ValidationError); !ok || e.Errors != ValidationErrorAlgorithm {
+			t.Errorf("[%v] Expected ValidationErrorAlgorithm when allow-listed, but was %v", alg, err)
+		}
+	}
+}
+
 func TestParseRequest(t *testing.T) {
 	// Bearer token request
 	for _, data := range tokenTestData {
@@ -0,0 +1,195 @@
+package token
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// ValidationOptions describe the registered claims a token must carry, in
+// addition to the exp and nbf times that are always checked when present.
+type ValidationOptions struct {
+	// Issuer, when set, must equal the iss claim.
+	Issuer string
+	// Audience, when set, must equal the aud claim or be one of its values.
+	Audience string
+	// Subject, when set, must equal the sub claim.
+	Subject string
+	// Algorithms, when set, lists the alg headers accepted; otherwise any
+	// registered signing method is, and keyFunc should check the token
+	// Method itself. The none algorithm is never accepted.
+	Algorithms []string
+	// Required claims must be present, e.g. "jti" or "exp".
+	Required []string
+	// Leeway allowed for clock skew when checking exp and nbf.
+	Leeway time.Duration
+	// Legacy also accepts exp and nbf claims written as formatted strings by
+	// earlier versions of this package, in the layout named by their tsf
+	// claim or LegacyFormat when there is none.
+	Legacy       bool
+	LegacyFormat string
+}
+
+// merge returns a copy of o with any set fields of with applied over it.
+func (o *ValidationOptions) merge(with *ValidationOptions) *ValidationOptions {
+	var ret ValidationOptions
+	if o != nil {
+		ret = *o
+		ret.Required = append([]string(nil), o.Required...)
+	}
+	if with != nil {
+		if with.Issuer != "" {
+			ret.Issuer = with.Issuer
+		}
+		if with.Audience != "" {
+			ret.Audience = with.Audience
+		}
+		if with.Subject != "" {
+			ret.Subject = with.Subject
+		}
+		if len(with.Algorithms) > 0 {
+			ret.Algorithms = with.Algorithms
+		}
+		if with.Leeway != 0 {
+			ret.Leeway = with.Leeway
+		}
+		if with.Legacy {
+			ret.Legacy = true
+		}
+		if with.LegacyFormat != "" {
+			ret.LegacyFormat = with.LegacyFormat
+		}
+		ret.Required = append(ret.Required, with.Required...)
+	}
+	return &ret
+}
+
+// allows reports whether a token signed with alg may be verified.
+func (o *ValidationOptions) allows(alg string) bool {
+	if strings.EqualFold(alg, "none") {
+		return false
+	}
+	if o == nil || len(o.Algorithms) == 0 {
+		return true
+	}
+	for _, a := range o.Algorithms {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *ValidationOptions) validate(t *Token, vErr *ValidationError) {
+	if o == nil {
+		o = &ValidationOptions{}
+	}
+	validateTimes(t, o, vErr)
+	for _, claim := range o.Required {
+		if _, ok := t.Claims[claim]; !ok {
+			vErr.err = "token is missing required claim " + claim
+			vErr.Errors |= ValidationErrorClaimRequired
+		}
+	}
+	if o.Issuer != "" {
+		if iss, _ := t.Claims["iss"].(string); iss != o.Issuer {
+			vErr.err = "token has an invalid issuer"
+			vErr.Errors |= ValidationErrorIssuer
+		}
+	}
+	if o.Audience != "" && !hasAudience(t.Claims["aud"], o.Audience) {
+		vErr.err = "token has an invalid audience"
+		vErr.Errors |= ValidationErrorAudience
+	}
+	if o.Subject != "" {
+		if sub, _ := t.Claims["sub"].(string); sub != o.Subject {
+			vErr.err = "token has an invalid subject"
+			vErr.Errors |= ValidationErrorSubject
+		}
+	}
+}
+
+func hasAudience(claim interface{}, expected string) bool {
+	switch aud := claim.(type) {
+	case string:
+		return aud == expected
+	case []string:
+		for _, a := range aud {
+			if a == expected {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, a := range aud {
+			if s, ok := a.(string); ok && s == expected {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// NumericDate returns t as seconds since the epoch, as used by the exp, iat
+// and nbf claims.
+func NumericDate(t time.Time) int64 {
+	return t.Unix()
+}
+
+// claimTime reads a NumericDate claim, or a formatted string claim when
+// legacy timestamps are accepted.
+func claimTime(t *Token, claim string, o *ValidationOptions) (time.Time, bool, error) {
+	switch v := t.Claims[claim].(type) {
+	case nil:
+		return time.Time{}, false, nil
+	case float64:
+		return time.Unix(int64(v), 0), true, nil
+	case int64:
+		return time.Unix(v, 0), true, nil
+	case int:
+		return time.Unix(int64(v), 0), true, nil
+	case json.Number:
+		n, err := v.Int64()
+		return time.Unix(n, 0), true, err
+	case string:
+		if o.Legacy {
+			format, ok := t.Claims["tsf"].(string)
+			if !ok {
+				format = o.LegacyFormat
+			}
+			if format == "" {
+				format = time.UnixDate
+			}
+			tm, err := time.ParseInLocation(format, v, TimeZone)
+			return tm, true, err
+		}
+	}
+	return time.Time{}, true, ErrInvalidTime
+}
+
+func validateTimes(t *Token, o *ValidationOptions, vErr *ValidationError) {
+	now := TimeFunc()
+	if expires, ok, err := claimTime(t, "exp", o); ok {
+		if err != nil {
+			vErr.err = err.Error()
+			vErr.Errors |= ValidationErrorInvalidTime
+		}
+		if err == nil {
+			if now.After(expires.Add(o.Leeway)) {
+				vErr.err = "token is expired"
+				vErr.Errors |= ValidationErrorExpired
+			}
+		}
+	}
+	if before, ok, err := claimTime(t, "nbf", o); ok {
+		if err != nil {
+			vErr.err = err.Error()
+			vErr.Errors |= ValidationErrorInvalidTime
+		}
+		if err == nil {
+			if now.Before(before.Add(-o.Leeway)) {
+				vErr.err = "token is not valid yet"
+				vErr.Errors |= ValidationErrorNotValidYet
+			}
+		}
+	}
+}
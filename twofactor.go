@@ -0,0 +1,333 @@
+package security
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thrisp/flotilla"
+	"github.com/thrisp/security/login"
+	"github.com/thrisp/security/token"
+	"github.com/thrisp/security/user"
+)
+
+// TOTP codes follow RFC 6238 with the parameters authenticator apps assume:
+// HMAC-SHA1, six digits and a thirty second step. Codes one step either side
+// of the current one are accepted for clock drift.
+const (
+	totpDigits = 6
+	totpStep   = 30
+	totpSkew   = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random base32 encoded TOTP secret.
+func GenerateTOTPSecret() string {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return totpEncoding.EncodeToString(b)
+}
+
+func totpKey(secret string) ([]byte, error) {
+	return totpEncoding.DecodeString(strings.ToUpper(strings.TrimRight(strings.Replace(secret, " ", "", -1), "=")))
+}
+
+func hotp(key []byte, counter uint64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	h := hmac.New(sha1.New, key)
+	h.Write(msg)
+	sum := h.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%1000000)
+}
+
+func totpCounter(t time.Time) uint64 {
+	return uint64(t.Unix() / totpStep)
+}
+
+// TOTPCode returns the code for secret at time t.
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpKey(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, totpCounter(t)), nil
+}
+
+// ValidTOTP reports whether code is valid for secret at time t, and the
+// time step it was valid for.
+func ValidTOTP(secret, code string, t time.Time) (uint64, bool) {
+	key, err := totpKey(secret)
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	now := totpCounter(t)
+	for c := now - totpSkew; c <= now+totpSkew; c++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, c)), []byte(code)) == 1 {
+			return c, true
+		}
+	}
+	return 0, false
+}
+
+// TOTPURI returns the otpauth:// URI authenticator apps enroll from, usually
+// displayed as a QR code.
+func TOTPURI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", totpDigits))
+	v.Set("period", fmt.Sprintf("%d", totpStep))
+	label := url.PathEscape(issuer + ":" + account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+// twoFactor returns the TwoFactor of usr when two-factor is enabled for it.
+func (s *Manager) twoFactor(usr user.User) (user.TwoFactor, bool) {
+	if !s.BoolSetting("two_factor") {
+		return nil, false
+	}
+	tf, ok := usr.(user.TwoFactor)
+	return tf, ok && tf.TwoFactorSecret() != ""
+}
+
+// checkTOTP verifies a code for usr, spending its time step so a code is
+// only accepted once.
+func (s *Manager) checkTOTP(usr user.User, secret, code string) bool {
+	now := token.TimeFunc()
+	counter, ok := ValidTOTP(secret, code, now)
+	if !ok {
+		return false
+	}
+	until := now.Add(time.Duration(2*totpSkew+1) * totpStep * time.Second)
+	fresh, err := s.tokens.Spend(fmt.Sprintf("totp:%s:%d", usr.Id(), counter), until)
+	return fresh && err == nil
+}
+
+// loginAndRedirect logs usr in and continues to next, or to an OAuth
+// authorization request interrupted by the login.
+func (s *Manager) loginAndRedirect(f flotilla.Ctx, usr user.User, remember bool, next string) {
+	s.LoginUser(usr, remember, f)
+	if authorize := resumeAuthorize(f); authorize != "" {
+		next = authorize
+	}
+	s.Flash(f, "login_successful")
+	f.Call("redirect", 302, next)
+}
+
+// loginOrPend logs usr in and continues to next, unless two-factor is
+// enabled for usr, when the login is held for a TOTP code first. Every login
+// but one with a passkey, itself a second factor, comes through here.
+func (s *Manager) loginOrPend(f flotilla.Ctx, usr user.User, remember bool, next string) {
+	if _, ok := s.twoFactor(usr); ok {
+		s.pendTwoFactor(f, usr, remember, next)
+		return
+	}
+	s.loginAndRedirect(f, usr, remember, next)
+}
+
+// checkSecondFactor verifies a TOTP code for usr, or else one of its
+// recovery codes, returning the recovery codes remaining when one was used
+// and -1 otherwise.
+func (s *Manager) checkSecondFactor(usr user.User, tf user.TwoFactor, code string) (int, bool) {
+	if s.checkTOTP(usr, tf.TwoFactorSecret(), code) {
+		return -1, true
+	}
+	return s.useRecoveryCode(usr, code)
+}
+
+// pendTwoFactor holds a password verified login in the session until a
+// TOTP code is given at the TWO_FACTOR_URL.
+func (s *Manager) pendTwoFactor(f flotilla.Ctx, usr user.User, remember bool, next string) {
+	pending := s.Token("two_factor", token.Claims{
+		"ut":       usr.Token("two_factor"),
+		"remember": remember,
+		"next":     next,
+		"exp":      token.NumericDate(s.Expires("two_factor_duration")),
+	})
+	f.Call("setsession", "two_factor", pending)
+	s.Flash(f, "two_factor_required")
+	f.Call("redirect", 302, s.BlueprintUrl("two_factor_url"))
+}
+
+func (s *Manager) pendingTwoFactor(f flotilla.Ctx) (*token.Token, user.User) {
+	p, _ := f.Call("getsession", "two_factor")
+	pending, _ := p.(string)
+	if pending == "" {
+		return nil, nil
+	}
+	tkn, err := s.Signatory("two_factor").Valid(pending)
+	if err != nil {
+		return nil, nil
+	}
+	usr, _ := validUserToken(s, tkn)
+	return tkn, usr
+}
+
+func (s *Manager) twoFactorExpired(f flotilla.Ctx, messages ...string) {
+	if len(messages) == 0 {
+		messages = []string{"two_factor_expired"}
+	}
+	f.Call("deletesession", "two_factor")
+	s.Flash(f, messages...)
+	f.Call("redirect", 303, s.BlueprintUrl(s.ManagerLogin()))
+}
+
+// twoFactorAttempts is the number of codes a pending login may try.
+func (s *Manager) twoFactorAttempts() int {
+	if n := settingInt(s, "two_factor_attempts"); n > 0 {
+		return n
+	}
+	return 1
+}
+
+// failTwoFactor counts a wrong code against the pending login, and against
+// the account when throttling, reporting whether the pending login may try
+// again.
+func (s *Manager) failTwoFactor(f flotilla.Ctx, tkn *token.Token, usr user.User) bool {
+	if s.BoolSetting("throttle") {
+		s.failAttempt(f, s.attemptKeys(request(f), usr.Email()))
+	}
+	return s.spendAttempt("two_factor:"+claimString(tkn.Claims["jti"]), s.twoFactorAttempts(), token.Expiry(tkn))
+}
+
+func getTwoFactor(f flotilla.Ctx) {
+	s := manager(f)
+	if _, usr := s.pendingTwoFactor(f); usr == nil {
+		s.twoFactorExpired(f)
+		return
+	}
+	f.Call("rendertemplate", "two_factor.html", nil)
+}
+
+func postTwoFactor(f flotilla.Ctx) {
+	posted(
+		f,
+		"two_factor",
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			tkn, usr := s.pendingTwoFactor(f)
+			if usr == nil {
+				s.twoFactorExpired(f)
+				return
+			}
+			tf, ok := s.twoFactor(usr)
+			code := formTwoFactorCode(form)
+			if !ok || s.attemptsSpent("two_factor:"+claimString(tkn.Claims["jti"]), s.twoFactorAttempts()) {
+				s.twoFactorExpired(f)
+				return
+			}
+			if refusal := s.refusal(s.attemptKeys(request(f), usr.Email())); s.BoolSetting("throttle") && refusal != nil {
+				f.Call("set", form.Tag(), form)
+				s.forwardTo(f, "two_factor.html", refusal...)
+				return
+			}
+			remaining, verified := s.checkSecondFactor(usr, tf, code)
+			if !verified {
+				if !s.failTwoFactor(f, tkn, usr) {
+					s.twoFactorExpired(f, "two_factor_attempts")
+					return
+				}
+				f.Call("set", form.Tag(), form)
+				s.forwardTo(f, "two_factor.html", "invalid_two_factor_code")
+				return
+			}
+			if s.Signatory("two_factor").Revoke(claimString(tkn.Claims["jti"]), token.Expiry(tkn)) != nil {
+				s.twoFactorExpired(f)
+				return
+			}
+			s.resetAccount(usr.Email())
+			if remaining >= 0 {
+				s.Flash(f, "recovery_code_used", strconv.Itoa(remaining))
+			}
+			f.Call("deletesession", "two_factor")
+			s.loginAndRedirect(f, usr, claimBool(tkn.Claims["remember"]), claimString(tkn.Claims["next"]))
+		},
+	)
+}
+
+// replaceTwoFactor wraps the two-factor setup routes, requiring a fresh
+// login to replace a second factor already enabled.
+func replaceTwoFactor(h flotilla.Manage) flotilla.Manage {
+	refresh := login.RefreshRequired(h)
+	return func(f flotilla.Ctx) {
+		s := manager(f)
+		if _, ok := s.twoFactor(s.CurrentUser()); ok {
+			refresh(f)
+			return
+		}
+		h(f)
+	}
+}
+
+// showOTPAuth sets the otpauth URI of secret on a two-factor setup form.
+func (s *Manager) showOTPAuth(f flotilla.Ctx, form Form, email, secret string) {
+	issuer := s.Setting("two_factor_issuer")
+	if issuer == "" {
+		issuer = request(f).Host
+	}
+	form.Fields(OTPAuth("otpauth").New(TOTPURI(issuer, email, secret)))
+	f.Call("set", form.Tag(), form)
+}
+
+func getTwoFactorSetup(f flotilla.Ctx) {
+	s := manager(f)
+	usr := s.CurrentUser()
+	if _, ok := usr.(user.TwoFactor); !ok {
+		f.Call("status", 404)
+		return
+	}
+	secret := GenerateTOTPSecret()
+	form := s.Forms.byKey("two_factor_setup").Fresh(token.Claims{
+		"forUser": usr.Email(),
+		"secret":  secret,
+	})
+	s.showOTPAuth(f, form, usr.Email(), secret)
+	f.Call("rendertemplate", "two_factor_setup.html", nil)
+}
+
+func postTwoFactorSetup(f flotilla.Ctx) {
+	posted(
+		f,
+		"two_factor_setup",
+		func(f flotilla.Ctx, s *Manager, form Form) {
+			usr := s.CurrentUser()
+			t, err := s.Signatory("signed").Valid(formSigned(form))
+			tf, ok := usr.(user.TwoFactor)
+			if err != nil || !ok || claimString(t.Claims["forUser"]) != usr.Email() {
+				s.formFail(f, form, "two_factor_setup.html")
+				return
+			}
+			// a mistyped code keeps the secret being enrolled
+			secret := claimString(t.Claims["secret"])
+			if !s.checkTOTP(usr, secret, formTwoFactorCode(form)) {
+				s.showOTPAuth(f, form, usr.Email(), secret)
+				s.forwardTo(f, "two_factor_setup.html", "invalid_two_factor_code")
+				return
+			}
+			if _, err = s.Signatory("signed").Consume(formSigned(form)); err == nil {
+				if err = tf.SetTwoFactorSecret(secret); err == nil {
+					_, err = s.Put(usr)
+				}
+			}
+			if err != nil {
+				s.formFail(f, form, "two_factor_setup.html")
+				return
+			}
+			s.redirectAfter(f, form, "two_factor_enabled")
+		},
+	)
+}
@@ -0,0 +1,89 @@
+package security
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238 appendix B SHA-1 vectors, truncated to six digits.
+var totpVectors = []struct {
+	unix int64
+	code string
+}{
+	{59, "287082"},
+	{1111111109, "081804"},
+	{1111111111, "050471"},
+	{1234567890, "005924"},
+	{2000000000, "279037"},
+	{20000000000, "353130"},
+}
+
+func TestTOTPCode(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	for _, v := range totpVectors {
+		if code, err := TOTPCode(secret, time.Unix(v.unix, 0)); err != nil || code != v.code {
+			t.Errorf("TOTP at %d expected %s, but was %s (%v)", v.unix, v.code, code, err)
+		}
+	}
+}
+
+func TestValidTOTP(t *testing.T) {
+	secret := GenerateTOTPSecret()
+	now := time.Unix(1111111111, 0)
+	for _, skew := range []time.Duration{-totpStep, 0, totpStep} {
+		code, _ := TOTPCode(secret, now.Add(skew*time.Second))
+		if _, ok := ValidTOTP(secret, code, now); !ok {
+			t.Errorf("expected code %v seconds away to be valid", skew)
+		}
+	}
+	stale, _ := TOTPCode(secret, now.Add(-3*totpStep*time.Second))
+	if _, ok := ValidTOTP(secret, stale, now); ok {
+		t.Errorf("expected a stale code to be rejected")
+	}
+	if _, ok := ValidTOTP("not base32!", "123456", now); ok {
+		t.Errorf("expected an invalid secret to be rejected")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	u, err := url.Parse(TOTPURI("Example App", "user@example.com", "JBSWY3DPEHPK3PXP"))
+	if err != nil {
+		t.Fatalf("invalid otpauth URI: %v", err)
+	}
+	q := u.Query()
+	if u.Scheme != "otpauth" || u.Host != "totp" || u.Path != "/Example App:user@example.com" {
+		t.Errorf("unexpected otpauth URI %s", u)
+	}
+	if q.Get("secret") != "JBSWY3DPEHPK3PXP" || q.Get("issuer") != "Example App" || q.Get("digits") != "6" {
+		t.Errorf("unexpected otpauth parameters %v", q)
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	s := New(WithUserDataStore(TDataStore()))
+	usr := s.Get("test-0").(*testUser)
+	codes, err := s.newRecoveryCodes(usr, usr)
+	if err != nil || len(codes) != RecoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, but was %v (%v)", RecoveryCodeCount, codes, err)
+	}
+	for i, h := range usr.Recovery {
+		if h == codes[i] || h != HashRecoveryCode(codes[i]) {
+			t.Errorf("expected only the hash of recovery code %d to be stored", i)
+		}
+	}
+	if _, ok := s.useRecoveryCode(usr, "aaaaa-aaaaa"); ok {
+		t.Errorf("expected an unknown recovery code to be rejected")
+	}
+	if remaining, ok := s.useRecoveryCode(usr, strings.ToUpper(codes[3])); !ok || remaining != RecoveryCodeCount-1 {
+		t.Errorf("expected recovery code to be accepted with %d remaining, but was %v %d", RecoveryCodeCount-1, ok, remaining)
+	}
+	if _, ok := s.useRecoveryCode(usr, codes[3]); ok {
+		t.Errorf("expected a recovery code to be used only once")
+	}
+	s.newRecoveryCodes(usr, usr)
+	if _, ok := s.useRecoveryCode(usr, codes[4]); ok {
+		t.Errorf("expected regenerating to invalidate previous recovery codes")
+	}
+}
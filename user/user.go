@@ -1,7 +1,10 @@
 package user
 
 import (
+	"time"
+
 	"github.com/thrisp/security/principal"
+	"github.com/thrisp/security/webauthn"
 )
 
 type User interface {
@@ -39,6 +42,49 @@ type Confirmable interface {
 	Confirmed() bool
 }
 
+// PasswordHash is a User whose password the security manager hashes and
+// verifies, keeping only the encoded hash. A user with no hash yet still
+// authenticates itself, and is given a hash when it succeeds.
+type PasswordHash interface {
+	PasswordHash() string
+	SetPasswordHash(string) error
+}
+
+// PasswordHistory is a User able to keep hashes of its recent passwords, most
+// recent first, so a password is not used again.
+type PasswordHistory interface {
+	PasswordHistory() []string
+	SetPasswordHistory([]string) error
+}
+
+// PasswordChanged is a User able to keep when its password last changed, so
+// reset links issued before then are refused.
+type PasswordChanged interface {
+	PasswordChanged() time.Time
+	SetPasswordChanged(time.Time) error
+}
+
+// TwoFactor is a User able to keep a TOTP secret for two-factor
+// authentication. An empty secret means two-factor is not enabled.
+type TwoFactor interface {
+	TwoFactorSecret() string
+	SetTwoFactorSecret(string) error
+}
+
+// RecoveryCodes is a User able to keep hashes of one-time recovery codes,
+// which stand in for a second factor when it is lost.
+type RecoveryCodes interface {
+	RecoveryCodes() []string
+	SetRecoveryCodes([]string) error
+}
+
+// Passkeys is a User able to keep WebAuthn credentials to log in with.
+type Passkeys interface {
+	Passkeys() []*webauthn.Credential
+	// SavePasskey adds a credential, or replaces the one with the same ID.
+	SavePasskey(*webauthn.Credential) error
+}
+
 var AnonymousUser = &anonymoususer{Identity: principal.Anonymous}
 
 type anonymoususer struct {
@@ -2,6 +2,9 @@ package user
 
 import "errors"
 
+// DataStore keeps users. New is given an email and the encoded hash of the
+// password chosen, which users without a PasswordHash verify in
+// Authenticate.
 type DataStore interface {
 	New(string, string) (User, error)
 	Get(string) User
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/thrisp/flotilla"
 	"github.com/thrisp/fork"
@@ -77,8 +78,21 @@ func claimString(i interface{}) string {
 	return ""
 }
 
+func claimTime(i interface{}) time.Time {
+	switch n := i.(type) {
+	case float64:
+		return time.Unix(int64(n), 0)
+	case int64:
+		return time.Unix(n, 0)
+	}
+	return time.Time{}
+}
+
 func claimBool(in interface{}) bool {
-	if rm, ok := in.(string); ok {
+	switch rm := in.(type) {
+	case bool:
+		return rm
+	case string:
 		if remember, err := strconv.ParseBool(rm); err == nil {
 			return remember
 		}
@@ -0,0 +1,124 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// maxDepth bounds the nesting of decoded CBOR, which WebAuthn never needs
+// deeper than a few levels.
+const maxDepth = 16
+
+// decodeCBOR decodes the first CBOR data item of b, returning it and the
+// number of bytes it occupied. Unsigned and negative integers decode to
+// int64, byte strings to []byte, text to string, arrays to []interface{} and
+// maps to map[interface{}]interface{}. Tags are skipped.
+func decodeCBOR(b []byte) (interface{}, int, error) {
+	return decodeItem(b, 0)
+}
+
+func decodeHead(b []byte) (major byte, arg uint64, n int, err error) {
+	if len(b) < 1 {
+		return 0, 0, 0, ErrMalformed
+	}
+	major, info := b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		return major, uint64(info), 1, nil
+	case info == 24 && len(b) >= 2:
+		return major, uint64(b[1]), 2, nil
+	case info == 25 && len(b) >= 3:
+		return major, uint64(binary.BigEndian.Uint16(b[1:])), 3, nil
+	case info == 26 && len(b) >= 5:
+		return major, uint64(binary.BigEndian.Uint32(b[1:])), 5, nil
+	case info == 27 && len(b) >= 9:
+		return major, binary.BigEndian.Uint64(b[1:]), 9, nil
+	}
+	return 0, 0, 0, ErrMalformed
+}
+
+func decodeItem(b []byte, depth int) (interface{}, int, error) {
+	if depth > maxDepth {
+		return nil, 0, ErrMalformed
+	}
+	major, arg, n, err := decodeHead(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, 0, ErrMalformed
+		}
+		return int64(arg), n, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, 0, ErrMalformed
+		}
+		return -1 - int64(arg), n, nil
+	case 2, 3:
+		if arg > uint64(len(b)-n) {
+			return nil, 0, ErrMalformed
+		}
+		end := n + int(arg)
+		if major == 2 {
+			return append([]byte(nil), b[n:end]...), end, nil
+		}
+		return string(b[n:end]), end, nil
+	case 4:
+		if arg > uint64(len(b)) {
+			return nil, 0, ErrMalformed
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items, n = append(items, item), n+m
+		}
+		return items, n, nil
+	case 5:
+		if arg > uint64(len(b)) {
+			return nil, 0, ErrMalformed
+		}
+		items := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			k, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			n += m
+			switch k.(type) {
+			case int64, string:
+			default:
+				return nil, 0, ErrMalformed
+			}
+			v, m, err := decodeItem(b[n:], depth+1)
+			if err != nil {
+				return nil, 0, err
+			}
+			items[k], n = v, n+m
+		}
+		return items, n, nil
+	case 6:
+		item, m, err := decodeItem(b[n:], depth+1)
+		return item, n + m, err
+	case 7:
+		switch {
+		case n == 1 && arg == 20:
+			return false, n, nil
+		case n == 1 && arg == 21:
+			return true, n, nil
+		case n == 1 && (arg == 22 || arg == 23):
+			return nil, n, nil
+		case n == 3:
+			return nil, n, nil
+		case n == 5:
+			return float64(math.Float32frombits(uint32(arg))), n, nil
+		case n == 9:
+			return math.Float64frombits(arg), n, nil
+		}
+	}
+	return nil, 0, ErrMalformed
+}
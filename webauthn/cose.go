@@ -0,0 +1,123 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"math/big"
+)
+
+// COSE algorithm identifiers of the public keys accepted, in the order they
+// are offered to authenticators.
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgES384 = -35
+	AlgES512 = -36
+	AlgRS256 = -257
+)
+
+var Algorithms = []int{AlgES256, AlgEdDSA, AlgES384, AlgES512, AlgRS256}
+
+// coseKey is a parsed COSE_Key credential public key.
+type coseKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+func coseInt(m map[interface{}]interface{}, k int64) (int64, bool) {
+	v, ok := m[k].(int64)
+	return v, ok
+}
+
+func coseBytes(m map[interface{}]interface{}, k int64) []byte {
+	v, _ := m[k].([]byte)
+	return v
+}
+
+// parseCOSEKey reads an EC2, OKP or RSA COSE_Key.
+func parseCOSEKey(b []byte) (*coseKey, int, error) {
+	item, n, err := decodeCBOR(b)
+	if err != nil {
+		return nil, 0, err
+	}
+	m, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, 0, ErrMalformed
+	}
+	kty, _ := coseInt(m, 1)
+	alg, _ := coseInt(m, 3)
+	switch kty {
+	case 2:
+		var curve elliptic.Curve
+		crv, _ := coseInt(m, -1)
+		switch {
+		case crv == 1 && alg == AlgES256:
+			curve = elliptic.P256()
+		case crv == 2 && alg == AlgES384:
+			curve = elliptic.P384()
+		case crv == 3 && alg == AlgES512:
+			curve = elliptic.P521()
+		default:
+			return nil, 0, ErrUnsupportedKey
+		}
+		x, y := new(big.Int).SetBytes(coseBytes(m, -2)), new(big.Int).SetBytes(coseBytes(m, -3))
+		if !curve.IsOnCurve(x, y) {
+			return nil, 0, ErrMalformed
+		}
+		return &coseKey{alg, &ecdsa.PublicKey{Curve: curve, X: x, Y: y}}, n, nil
+	case 1:
+		crv, _ := coseInt(m, -1)
+		x := coseBytes(m, -2)
+		if crv != 6 || alg != AlgEdDSA || len(x) != ed25519.PublicKeySize {
+			return nil, 0, ErrUnsupportedKey
+		}
+		return &coseKey{alg, ed25519.PublicKey(x)}, n, nil
+	case 3:
+		nb, eb := coseBytes(m, -1), coseBytes(m, -2)
+		if alg != AlgRS256 || len(nb) == 0 || len(eb) == 0 || len(eb) > 4 {
+			return nil, 0, ErrUnsupportedKey
+		}
+		e := new(big.Int).SetBytes(eb)
+		return &coseKey{alg, &rsa.PublicKey{N: new(big.Int).SetBytes(nb), E: int(e.Int64())}}, n, nil
+	}
+	return nil, 0, ErrUnsupportedKey
+}
+
+func (k *coseKey) hash() crypto.Hash {
+	switch k.alg {
+	case AlgES384:
+		return crypto.SHA384
+	case AlgES512:
+		return crypto.SHA512
+	}
+	return crypto.SHA256
+}
+
+// verify checks a WebAuthn signature, ASN.1 DER for ECDSA, over message.
+func (k *coseKey) verify(message, signature []byte) error {
+	if pk, ok := k.key.(ed25519.PublicKey); ok {
+		if !ed25519.Verify(pk, message, signature) {
+			return ErrSignature
+		}
+		return nil
+	}
+	h := k.hash().New()
+	h.Write(message)
+	digest := h.Sum(nil)
+	switch pk := k.key.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pk, digest, signature) {
+			return ErrSignature
+		}
+	case *rsa.PublicKey:
+		if rsa.VerifyPKCS1v15(pk, k.hash(), digest, signature) != nil {
+			return ErrSignature
+		}
+	default:
+		return ErrUnsupportedKey
+	}
+	return nil
+}
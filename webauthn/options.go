@@ -0,0 +1,99 @@
+package webauthn
+
+// Timeout is the time in milliseconds browsers are given to complete a
+// ceremony.
+var Timeout = 300000
+
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+type AuthenticatorSelection struct {
+	ResidentKey      string `json:"residentKey"`
+	UserVerification string `json:"userVerification"`
+}
+
+// CreationOptions are the publicKey options of navigator.credentials.create,
+// with binary values base64url encoded.
+type CreationOptions struct {
+	Challenge              string                  `json:"challenge"`
+	RP                     RelyingPartyEntity      `json:"rp"`
+	User                   UserEntity              `json:"user"`
+	PubKeyCredParams       []CredentialParameter   `json:"pubKeyCredParams"`
+	Timeout                int                     `json:"timeout"`
+	ExcludeCredentials     []*CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection  `json:"authenticatorSelection"`
+	Attestation            string                  `json:"attestation"`
+}
+
+// RequestOptions are the publicKey options of navigator.credentials.get,
+// with binary values base64url encoded.
+type RequestOptions struct {
+	Challenge        string                  `json:"challenge"`
+	RPID             string                  `json:"rpId"`
+	Timeout          int                     `json:"timeout"`
+	AllowCredentials []*CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                  `json:"userVerification"`
+}
+
+func descriptors(creds []*Credential) []*CredentialDescriptor {
+	ds := make([]*CredentialDescriptor, len(creds))
+	for i, c := range creds {
+		ds[i] = &CredentialDescriptor{Type: "public-key", ID: Encoding.EncodeToString(c.ID)}
+	}
+	return ds
+}
+
+func (rp *RelyingParty) userVerification() string {
+	if rp.UserVerification {
+		return "required"
+	}
+	return "preferred"
+}
+
+// CreationOptions returns the options registering a credential for a user,
+// excluding credentials the user already has.
+func (rp *RelyingParty) CreationOptions(challenge string, userID []byte, name, displayName string, exclude []*Credential) *CreationOptions {
+	params := make([]CredentialParameter, len(Algorithms))
+	for i, alg := range Algorithms {
+		params[i] = CredentialParameter{Type: "public-key", Alg: alg}
+	}
+	return &CreationOptions{
+		Challenge:              challenge,
+		RP:                     RelyingPartyEntity{ID: rp.ID, Name: rp.Name},
+		User:                   UserEntity{ID: Encoding.EncodeToString(userID), Name: name, DisplayName: displayName},
+		PubKeyCredParams:       params,
+		Timeout:                Timeout,
+		ExcludeCredentials:     descriptors(exclude),
+		AuthenticatorSelection: AuthenticatorSelection{ResidentKey: "preferred", UserVerification: rp.userVerification()},
+		Attestation:            "none",
+	}
+}
+
+// RequestOptions returns the options asserting one of allow.
+func (rp *RelyingParty) RequestOptions(challenge string, allow []*Credential) *RequestOptions {
+	return &RequestOptions{
+		Challenge:        challenge,
+		RPID:             rp.ID,
+		Timeout:          Timeout,
+		AllowCredentials: descriptors(allow),
+		UserVerification: rp.userVerification(),
+	}
+}
@@ -0,0 +1,251 @@
+// Package webauthn verifies WebAuthn registration and assertion ceremonies
+// for a relying party, so users may log in with passkeys and security keys.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+)
+
+var (
+	ErrMalformed              = errors.New("webauthn: malformed data")
+	ErrCeremonyType           = errors.New("webauthn: client data is for another ceremony")
+	ErrChallenge              = errors.New("webauthn: challenge does not match")
+	ErrOrigin                 = errors.New("webauthn: origin is not allowed")
+	ErrRelyingParty           = errors.New("webauthn: authenticator data is for another relying party")
+	ErrUserPresence           = errors.New("webauthn: user was not present")
+	ErrUserVerification       = errors.New("webauthn: user was not verified")
+	ErrNoCredential           = errors.New("webauthn: authenticator data has no attested credential")
+	ErrUnsupportedKey         = errors.New("webauthn: credential public key is unsupported")
+	ErrUnsupportedAttestation = errors.New("webauthn: attestation format is unsupported")
+	ErrSignature              = errors.New("webauthn: invalid signature")
+	ErrSignCount              = errors.New("webauthn: signature counter did not increase, the authenticator may be cloned")
+)
+
+// Encoding is the unpadded base64url encoding WebAuthn uses for binary
+// values in JSON.
+var Encoding = base64.RawURLEncoding
+
+// NewChallenge returns a random, encoded challenge for a ceremony.
+func NewChallenge() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return Encoding.EncodeToString(b)
+}
+
+// RelyingParty verifies ceremonies for the site ID, e.g. "example.com",
+// served from one of Origins, e.g. "https://example.com".
+type RelyingParty struct {
+	ID      string
+	Name    string
+	Origins []string
+	// UserVerification requires the authenticator to verify the user, by PIN
+	// or biometric, and not only their presence.
+	UserVerification bool
+}
+
+// Credential is a registered public key credential.
+type Credential struct {
+	ID        []byte `json:"id"`
+	PublicKey []byte `json:"publicKey"`
+	SignCount uint32 `json:"signCount"`
+	AAGUID    []byte `json:"aaguid,omitempty"`
+}
+
+type clientData struct {
+	Type      string `json:"type"`
+	Challenge string `json:"challenge"`
+	Origin    string `json:"origin"`
+}
+
+func (rp *RelyingParty) verifyClientData(raw []byte, ceremony, challenge string) error {
+	var cd clientData
+	if err := json.Unmarshal(raw, &cd); err != nil {
+		return ErrMalformed
+	}
+	if cd.Type != ceremony {
+		return ErrCeremonyType
+	}
+	if challenge == "" || subtle.ConstantTimeCompare([]byte(cd.Challenge), []byte(challenge)) != 1 {
+		return ErrChallenge
+	}
+	for _, o := range rp.Origins {
+		if cd.Origin == o {
+			return nil
+		}
+	}
+	return ErrOrigin
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+	aaguid    []byte
+	credID    []byte
+	key       *coseKey
+	keyBytes  []byte
+}
+
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	if len(b) < 37 {
+		return nil, ErrMalformed
+	}
+	ad := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+	if ad.flags&flagAttested == 0 {
+		return ad, nil
+	}
+	rest := b[37:]
+	if len(rest) < 18 {
+		return nil, ErrMalformed
+	}
+	ad.aaguid = rest[:16]
+	idLen := int(binary.BigEndian.Uint16(rest[16:18]))
+	if len(rest) < 18+idLen {
+		return nil, ErrMalformed
+	}
+	ad.credID, rest = rest[18:18+idLen], rest[18+idLen:]
+	key, n, err := parseCOSEKey(rest)
+	if err != nil {
+		return nil, err
+	}
+	ad.key, ad.keyBytes = key, rest[:n]
+	return ad, nil
+}
+
+func (rp *RelyingParty) verifyAuthenticatorData(ad *authenticatorData) error {
+	want := sha256.Sum256([]byte(rp.ID))
+	if subtle.ConstantTimeCompare(ad.rpIDHash, want[:]) != 1 {
+		return ErrRelyingParty
+	}
+	if ad.flags&flagUserPresent == 0 {
+		return ErrUserPresence
+	}
+	if rp.UserVerification && ad.flags&flagUserVerified == 0 {
+		return ErrUserVerification
+	}
+	return nil
+}
+
+// VerifyRegistration verifies the response to a registration ceremony begun
+// with challenge, returning the new credential. Attestation statements of
+// the none and packed formats are accepted; attestation is not checked
+// against any trusted roots.
+func (rp *RelyingParty) VerifyRegistration(challenge string, clientDataJSON, attestationObject []byte) (*Credential, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.create", challenge); err != nil {
+		return nil, err
+	}
+	item, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, err
+	}
+	att, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return nil, ErrMalformed
+	}
+	rawAuthData, _ := att["authData"].([]byte)
+	format, _ := att["fmt"].(string)
+	stmt, _ := att["attStmt"].(map[interface{}]interface{})
+	ad, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, err
+	}
+	if err = rp.verifyAuthenticatorData(ad); err != nil {
+		return nil, err
+	}
+	if ad.key == nil {
+		return nil, ErrNoCredential
+	}
+	if err = verifyAttestation(format, stmt, ad, rawAuthData, clientDataJSON); err != nil {
+		return nil, err
+	}
+	return &Credential{
+		ID:        append([]byte(nil), ad.credID...),
+		PublicKey: append([]byte(nil), ad.keyBytes...),
+		SignCount: ad.signCount,
+		AAGUID:    append([]byte(nil), ad.aaguid...),
+	}, nil
+}
+
+func verifyAttestation(format string, stmt map[interface{}]interface{}, ad *authenticatorData, rawAuthData, clientDataJSON []byte) error {
+	switch format {
+	case "none":
+		return nil
+	case "packed":
+		sig, _ := stmt["sig"].([]byte)
+		alg, _ := stmt["alg"].(int64)
+		cdHash := sha256.Sum256(clientDataJSON)
+		signed := append(append([]byte(nil), rawAuthData...), cdHash[:]...)
+		x5c, _ := stmt["x5c"].([]interface{})
+		if len(x5c) == 0 {
+			if alg != ad.key.alg {
+				return ErrUnsupportedAttestation
+			}
+			return ad.key.verify(signed, sig)
+		}
+		der, _ := x5c[0].([]byte)
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return ErrMalformed
+		}
+		return (&coseKey{alg: alg, key: cert.PublicKey}).verify(signed, sig)
+	}
+	return ErrUnsupportedAttestation
+}
+
+// VerifyAssertion verifies the response to an authentication ceremony begun
+// with challenge, signed by cred. It returns the new signature counter, to
+// be stored with the credential.
+func (rp *RelyingParty) VerifyAssertion(challenge string, cred *Credential, clientDataJSON, authenticatorData, signature []byte) (uint32, error) {
+	if err := rp.verifyClientData(clientDataJSON, "webauthn.get", challenge); err != nil {
+		return 0, err
+	}
+	ad, err := parseAuthenticatorData(authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+	if err = rp.verifyAuthenticatorData(ad); err != nil {
+		return 0, err
+	}
+	key, _, err := parseCOSEKey(cred.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+	cdHash := sha256.Sum256(clientDataJSON)
+	if err = key.verify(append(append([]byte(nil), authenticatorData...), cdHash[:]...), signature); err != nil {
+		return 0, err
+	}
+	if (ad.signCount != 0 || cred.SignCount != 0) && ad.signCount <= cred.SignCount {
+		return 0, ErrSignCount
+	}
+	return ad.signCount, nil
+}
+
+// FindCredential returns the credential of creds with id.
+func FindCredential(creds []*Credential, id []byte) *Credential {
+	for _, c := range creds {
+		if bytes.Equal(c.ID, id) {
+			return c
+		}
+	}
+	return nil
+}
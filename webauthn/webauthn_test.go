@@ -0,0 +1,317 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+)
+
+// cborMap is a map encoded with its keys in the given order.
+type cborMap []cborPair
+
+type cborPair struct {
+	k, v interface{}
+}
+
+func cborHead(major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return []byte{major<<5 | byte(n)}
+	case n < 0x100:
+		return []byte{major<<5 | 24, byte(n)}
+	case n < 0x10000:
+		return []byte{major<<5 | 25, byte(n >> 8), byte(n)}
+	}
+	b := make([]byte, 5)
+	b[0] = major<<5 | 26
+	binary.BigEndian.PutUint32(b[1:], uint32(n))
+	return b
+}
+
+func encodeCBOR(v interface{}) []byte {
+	switch x := v.(type) {
+	case int:
+		if x < 0 {
+			return cborHead(1, uint64(-1-x))
+		}
+		return cborHead(0, uint64(x))
+	case []byte:
+		return append(cborHead(2, uint64(len(x))), x...)
+	case string:
+		return append(cborHead(3, uint64(len(x))), x...)
+	case []interface{}:
+		b := cborHead(4, uint64(len(x)))
+		for _, i := range x {
+			b = append(b, encodeCBOR(i)...)
+		}
+		return b
+	case cborMap:
+		b := cborHead(5, uint64(len(x)))
+		for _, p := range x {
+			b = append(append(b, encodeCBOR(p.k)...), encodeCBOR(p.v)...)
+		}
+		return b
+	}
+	panic("unsupported cbor value")
+}
+
+// testAuthenticator is a software authenticator holding one credential.
+type testAuthenticator struct {
+	signer crypto.Signer
+	credID []byte
+	count  uint32
+	flags  byte
+}
+
+func newTestAuthenticator(t *testing.T, ed bool) *testAuthenticator {
+	a := &testAuthenticator{credID: make([]byte, 16), count: 1, flags: flagUserPresent | flagUserVerified}
+	rand.Read(a.credID)
+	var err error
+	if ed {
+		_, a.signer, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func (a *testAuthenticator) coseKey() []byte {
+	switch pk := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		return encodeCBOR(cborMap{{1, 2}, {3, AlgES256}, {-1, 1}, {-2, pk.X.FillBytes(make([]byte, 32))}, {-3, pk.Y.FillBytes(make([]byte, 32))}})
+	case ed25519.PublicKey:
+		return encodeCBOR(cborMap{{1, 1}, {3, AlgEdDSA}, {-1, 6}, {-2, []byte(pk)}})
+	}
+	return nil
+}
+
+func (a *testAuthenticator) sign(message []byte) []byte {
+	var sig []byte
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		sig, _ = a.signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		sig, _ = a.signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	return sig
+}
+
+func (a *testAuthenticator) authData(rpID string, attested bool) []byte {
+	h := sha256.Sum256([]byte(rpID))
+	b := append([]byte(nil), h[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttested
+	}
+	b = append(b, flags, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(b[33:], a.count)
+	if attested {
+		b = append(b, make([]byte, 16)...)
+		b = append(b, byte(len(a.credID)>>8), byte(len(a.credID)))
+		b = append(append(b, a.credID...), a.coseKey()...)
+	}
+	return b
+}
+
+func testClientData(ceremony, challenge, origin string) []byte {
+	b, _ := json.Marshal(map[string]interface{}{"type": ceremony, "challenge": challenge, "origin": origin, "crossOrigin": false})
+	return b
+}
+
+func (a *testAuthenticator) register(rpID, challenge, origin, format string) ([]byte, []byte) {
+	cd := testClientData("webauthn.create", challenge, origin)
+	ad := a.authData(rpID, true)
+	stmt := cborMap{}
+	if format == "packed" {
+		cdHash := sha256.Sum256(cd)
+		alg := AlgES256
+		if _, ok := a.signer.(ed25519.PrivateKey); ok {
+			alg = AlgEdDSA
+		}
+		stmt = cborMap{{"alg", alg}, {"sig", a.sign(append(append([]byte(nil), ad...), cdHash[:]...))}}
+	}
+	return cd, encodeCBOR(cborMap{{"fmt", format}, {"attStmt", stmt}, {"authData", ad}})
+}
+
+func (a *testAuthenticator) assert(rpID, challenge, origin string) ([]byte, []byte, []byte) {
+	a.count++
+	cd := testClientData("webauthn.get", challenge, origin)
+	ad := a.authData(rpID, false)
+	cdHash := sha256.Sum256(cd)
+	return cd, ad, a.sign(append(append([]byte(nil), ad...), cdHash[:]...))
+}
+
+var testRP = &RelyingParty{ID: "example.com", Name: "Example", Origins: []string{"https://example.com"}}
+
+func TestCeremonies(t *testing.T) {
+	for name, ed := range map[string]bool{"ES256": false, "EdDSA": true} {
+		for _, format := range []string{"none", "packed"} {
+			a := newTestAuthenticator(t, ed)
+			ch := NewChallenge()
+			cd, att := a.register("example.com", ch, "https://example.com", format)
+			cred, err := testRP.VerifyRegistration(ch, cd, att)
+			if err != nil {
+				t.Errorf("[%s %s] registration failed: %v", name, format, err)
+				continue
+			}
+			if string(cred.ID) != string(a.credID) || cred.SignCount != 1 {
+				t.Errorf("[%s %s] unexpected credential %+v", name, format, cred)
+			}
+			ch = NewChallenge()
+			cd, ad, sig := a.assert("example.com", ch, "https://example.com")
+			count, err := testRP.VerifyAssertion(ch, cred, cd, ad, sig)
+			if err != nil || count != 2 {
+				t.Errorf("[%s %s] assertion failed: %d %v", name, format, count, err)
+			}
+		}
+	}
+}
+
+func TestRegistrationErrors(t *testing.T) {
+	a := newTestAuthenticator(t, false)
+	ch := NewChallenge()
+	uv := &RelyingParty{ID: "example.com", Origins: []string{"https://example.com"}, UserVerification: true}
+	cases := []struct {
+		name      string
+		rp        *RelyingParty
+		challenge string
+		rpID      string
+		origin    string
+		format    string
+		flags     byte
+		err       error
+	}{
+		{"challenge", testRP, NewChallenge(), "example.com", "https://example.com", "none", 0x05, ErrChallenge},
+		{"origin", testRP, ch, "example.com", "https://evil.example", "none", 0x05, ErrOrigin},
+		{"rp id", testRP, ch, "evil.example", "https://example.com", "none", 0x05, ErrRelyingParty},
+		{"presence", testRP, ch, "example.com", "https://example.com", "none", 0x04, ErrUserPresence},
+		{"verification", uv, ch, "example.com", "https://example.com", "none", 0x01, ErrUserVerification},
+		{"format", testRP, ch, "example.com", "https://example.com", "fido-u2f", 0x05, ErrUnsupportedAttestation},
+	}
+	for _, c := range cases {
+		a.flags = c.flags
+		cd, att := a.register(c.rpID, ch, c.origin, c.format)
+		if _, err := c.rp.VerifyRegistration(c.challenge, cd, att); err != c.err {
+			t.Errorf("[%s] expected %v, but was %v", c.name, c.err, err)
+		}
+	}
+	a.flags = 0x05
+	cd, att := a.register("example.com", ch, "https://example.com", "none")
+	if _, err := testRP.VerifyRegistration(ch, testClientData("webauthn.get", ch, "https://example.com"), att); err != ErrCeremonyType {
+		t.Errorf("expected ErrCeremonyType, but was %v", err)
+	}
+	if _, err := testRP.VerifyRegistration(ch, cd, att[:len(att)-8]); err == nil {
+		t.Errorf("expected a truncated attestation object to be rejected")
+	}
+}
+
+func TestAssertionErrors(t *testing.T) {
+	a := newTestAuthenticator(t, false)
+	ch := NewChallenge()
+	cd, att := a.register("example.com", ch, "https://example.com", "none")
+	cred, err := testRP.VerifyRegistration(ch, cd, att)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cd, ad, sig := a.assert("example.com", ch, "https://example.com")
+	sig[len(sig)-1] ^= 0xff
+	if _, err := testRP.VerifyAssertion(ch, cred, cd, ad, sig); err != ErrSignature {
+		t.Errorf("expected ErrSignature for a tampered signature, but was %v", err)
+	}
+
+	other := newTestAuthenticator(t, false)
+	cd, ad, sig = other.assert("example.com", ch, "https://example.com")
+	if _, err := testRP.VerifyAssertion(ch, cred, cd, ad, sig); err != ErrSignature {
+		t.Errorf("expected ErrSignature for another key, but was %v", err)
+	}
+
+	cd, ad, sig = a.assert("example.com", ch, "https://example.com")
+	count, err := testRP.VerifyAssertion(ch, cred, cd, ad, sig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred.SignCount = count
+	if _, err := testRP.VerifyAssertion(ch, cred, cd, ad, sig); err != ErrSignCount {
+		t.Errorf("expected ErrSignCount for a replayed assertion, but was %v", err)
+	}
+}
+
+// fixture is a ceremony recorded from a software authenticator.
+type fixture struct {
+	RPID              string      `json:"rpId"`
+	Origin            string      `json:"origin"`
+	Challenge         string      `json:"challenge"`
+	ClientDataJSON    string      `json:"clientDataJSON"`
+	AttestationObject string      `json:"attestationObject,omitempty"`
+	AuthenticatorData string      `json:"authenticatorData,omitempty"`
+	Signature         string      `json:"signature,omitempty"`
+	Credential        *Credential `json:"credential,omitempty"`
+}
+
+func loadFixture(t *testing.T, name string) *fixture {
+	b, err := ioutil.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fixture{}
+	if err = json.Unmarshal(b, f); err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func decoded(t *testing.T, s string) []byte {
+	b, err := Encoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestRecordedFixtures(t *testing.T) {
+	reg := loadFixture(t, "registration.json")
+	rp := &RelyingParty{ID: reg.RPID, Origins: []string{reg.Origin}}
+	cred, err := rp.VerifyRegistration(reg.Challenge, decoded(t, reg.ClientDataJSON), decoded(t, reg.AttestationObject))
+	if err != nil {
+		t.Fatalf("recorded registration failed: %v", err)
+	}
+
+	as := loadFixture(t, "assertion.json")
+	if string(as.Credential.PublicKey) != string(cred.PublicKey) {
+		t.Errorf("recorded assertion is for another credential")
+	}
+	if _, err = rp.VerifyAssertion(as.Challenge, cred, decoded(t, as.ClientDataJSON), decoded(t, as.AuthenticatorData), decoded(t, as.Signature)); err != nil {
+		t.Errorf("recorded assertion failed: %v", err)
+	}
+	if _, err = rp.VerifyAssertion(reg.Challenge, cred, decoded(t, as.ClientDataJSON), decoded(t, as.AuthenticatorData), decoded(t, as.Signature)); err != ErrChallenge {
+		t.Errorf("expected recorded assertion to fail for another challenge, but was %v", err)
+	}
+}
+
+func TestCBOR(t *testing.T) {
+	item, n, err := decodeCBOR(encodeCBOR(cborMap{{"a", []interface{}{1, -2, "x"}}, {3, []byte{1, 2}}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := item.(map[interface{}]interface{})
+	arr := m["a"].([]interface{})
+	if n != 12 || arr[0] != int64(1) || arr[1] != int64(-2) || arr[2] != "x" || string(m[int64(3)].([]byte)) != "\x01\x02" {
+		t.Errorf("unexpected decoding %v (%d bytes)", item, n)
+	}
+	for _, bad := range [][]byte{{0x5a, 0xff, 0xff, 0xff, 0xff}, {0xa1}, {0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}} {
+		if _, _, err := decodeCBOR(bad); err == nil {
+			t.Errorf("expected %x to be rejected", bad)
+		}
+	}
+}